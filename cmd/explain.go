@@ -0,0 +1,75 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/gepaplexx/multena-rbac-collector/collector"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v3"
+)
+
+var (
+	explainUsers   bool
+	explainGroups  bool
+	explainProfile string
+)
+
+// explainCmd represents the explain command
+var explainCmd = &cobra.Command{
+	Use:   "explain SUBJECT [NAMESPACE]",
+	Short: "Explains why a subject may access namespaces",
+	Long: `Collects RBAC permissions and prints the bindings, roles and rules granting a subject access,
+for all namespaces or only the given one (including cluster-wide grants). Users and groups are both looked up unless --user or --group is set.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+		config := loadConfig()
+		initializeKubernetesClient()
+
+		if _, ok := config.Profiles[explainProfile]; explainProfile != collector.DefaultProfile && !ok {
+			log.Fatal().Str("profile", explainProfile).Msg("Unknown profile")
+		}
+		snapshot, err := listResources(config, func() {})
+		if err != nil {
+			log.Fatal().Err(err).Msg("error listing resources")
+		}
+		permissions := snapshot.Collect(config)[explainProfile]
+
+		users, groups := explainUsers || !explainGroups, explainGroups || !explainUsers
+		explanation := permissions.Provenance.Explain(args[0], users, groups)
+		if len(args) == 2 {
+			namespace := args[1]
+			filtered := map[string][]collector.Grant{}
+			for _, key := range []string{namespace, collector.ClusterWide} {
+				if grants := explanation[key]; len(grants) > 0 {
+					filtered[key] = grants
+				}
+			}
+			explanation = filtered
+		}
+		if len(explanation) == 0 {
+			log.Info().Str("subject", args[0]).Msg("Subject has no access")
+			return
+		}
+
+		encoder := yaml.NewEncoder(os.Stdout)
+		defer encoder.Close()
+		err = encoder.Encode(explanation)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error marshalling explanation")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(explainCmd)
+	explainCmd.Flags().BoolVar(&explainUsers, "user", false, "only look up users")
+	explainCmd.Flags().BoolVar(&explainGroups, "group", false, "only look up groups")
+	explainCmd.Flags().StringVar(&explainProfile, "profile", collector.DefaultProfile, "explain the permissions of the given profile instead of the default matcher")
+}
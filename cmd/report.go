@@ -0,0 +1,98 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gepaplexx/multena-rbac-collector/collector"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportOutput  string
+	reportProfile string
+)
+
+// reportCmd groups the analysis reports
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Prints analysis reports of the cluster's RBAC configuration",
+}
+
+// escalationCmd represents the report escalation command
+var escalationCmd = &cobra.Command{
+	Use:   "escalation",
+	Short: "Lists subjects who could grant themselves access through RBAC self-service",
+	Long: `Lists, per namespace, the subjects without access that could obtain it through RBAC self-service:
+by binding a role granting access (bind and create RoleBindings) or by writing roles with permissions they do not hold (escalate).
+Abilities granted by ClusterRoleBindings are reported for #cluster-wide.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+		config := loadConfig()
+		if reportOutput != "table" && reportOutput != "json" {
+			log.Fatal().Str("output", reportOutput).Msg("Unknown output, expected table or json")
+		}
+		if reportProfile != collector.DefaultProfile {
+			matcher, ok := config.Profiles[reportProfile]
+			if !ok {
+				log.Fatal().Str("profile", reportProfile).Msg("Unknown profile")
+			}
+			config.Matcher = matcher
+		}
+		initializeKubernetesClient()
+
+		snapshot, err := listResources(config, func() {})
+		if err != nil {
+			log.Fatal().Err(err).Msg("error listing resources")
+		}
+		escalations := snapshot.Escalations(config)
+		if reportOutput == "json" {
+			err = printEscalationsJSON(os.Stdout, escalations)
+		} else {
+			err = printEscalationsTable(os.Stdout, escalations)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("error printing report")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.AddCommand(escalationCmd)
+	reportCmd.PersistentFlags().StringVarP(&reportOutput, "output", "o", "table", "output format, table or json")
+	reportCmd.PersistentFlags().StringVar(&reportProfile, "profile", collector.DefaultProfile, "report access of the given profile instead of the default matcher")
+}
+
+func printEscalationsJSON(out io.Writer, escalations []collector.Escalation) error {
+	if escalations == nil {
+		escalations = []collector.Escalation{}
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(escalations)
+}
+
+func printEscalationsTable(out io.Writer, escalations []collector.Escalation) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tKIND\tSUBJECT\tPATH\tROLES\tBINDINGS")
+	for _, e := range escalations {
+		roles := strings.Join(e.Roles, ",")
+		if roles == "" {
+			roles = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Namespace, e.Kind, e.Subject, e.Path, roles, strings.Join(e.Bindings, ","))
+	}
+	return w.Flush()
+}
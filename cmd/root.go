@@ -4,11 +4,17 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
 
 	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
 
+	"github.com/gepaplexx/multena-rbac-collector/collector"
+
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -21,8 +27,10 @@ var (
 	cfgFile        string
 	kubeconfigPath string
 	clientset      *kubernetes.Clientset
+	dynamicClient  dynamic.Interface
 	cmName         string
 	cmNamespace    string
+	outputFormat   string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -51,6 +59,7 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cmName, "cmName", "", "in cluster name of the ConfigMap to store the RBAC data")
 	rootCmd.PersistentFlags().StringVar(&cmNamespace, "cmNamespace", "", "cluster namespace of the ConfigMap to store the RBAC data")
 	rootCmd.MarkFlagsRequiredTogether("cmName", "cmNamespace")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "format", "", "schema of the permission document, flat or subjects (default is flat or the format of the config file)")
 
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
@@ -77,6 +86,52 @@ func initializeKubernetesClient() {
 	if err != nil {
 		log.Fatal().Err(err).Msgf("Could not create Kubernetes clientset")
 	}
+
+	dynamicClient, err = dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Could not create Kubernetes dynamic client")
+	}
+}
+
+// loadConfig reads the collector configuration from the config file and applies command line overrides.
+func loadConfig() collector.Config {
+	config := readConfigFile()
+	if outputFormat != "" {
+		config.Format = collector.Format(outputFormat)
+	}
+	err := config.Validate()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+	return config
+}
+
+// readConfigFile reads the collector configuration from the --config file or, if not set,
+// from $HOME/.multena-rbac-collector.yaml when it exists. Missing settings keep their defaults.
+func readConfigFile() collector.Config {
+	config := collector.DefaultConfig()
+	path := cfgFile
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return config
+		}
+		path = filepath.Join(home, ".multena-rbac-collector.yaml")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if cfgFile == "" && errors.Is(err, fs.ErrNotExist) {
+			return config
+		}
+		log.Fatal().Err(err).Str("path", path).Msg("Could not read config file")
+	}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", path).Msg("Could not parse config file")
+	}
+	log.Info().Str("path", path).Msg("Loaded config file")
+	return config
 }
 
 func logCommit() {
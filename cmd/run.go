@@ -6,6 +6,7 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"time"
 
@@ -22,6 +23,8 @@ import (
 	// Make sure to import the necessary packages for your logic
 )
 
+var report bool
+
 // runCmd represents the run command
 var runCmd = &cobra.Command{
 	Use:   "run",
@@ -31,10 +34,11 @@ In cluster ConfigMap can be specified with the --cmName and --cmNamespace flags.
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 		logCommit()
+		config := loadConfig()
 		initializeKubernetesClient()
 		log.Info().Msg("Starting RBAC analyzer...")
 
-		bar := progressbar.NewOptions(8,
+		bar := progressbar.NewOptions(12,
 			progressbar.OptionEnableColorCodes(true),
 			progressbar.OptionShowBytes(false),
 			progressbar.OptionSetWidth(15),
@@ -43,48 +47,28 @@ In cluster ConfigMap can be specified with the --cmName and --cmNamespace flags.
 
 		start := time.Now()
 
-		roles, err := clientset.RbacV1().Roles(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			log.Error().Err(err).Msg("error getting roles")
-			return
-		}
-		_ = bar.Add(1)
-
-		clusterRoles, err := clientset.RbacV1().ClusterRoles().List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			log.Error().Err(err).Msg("error getting cluster roles")
-			return
-		}
-		_ = bar.Add(1)
-
-		rolesWithPerm, clusterRolesWithPerm := collector.GetRoles(*roles, *clusterRoles)
-		_ = bar.Add(1)
-
-		roleBindings, err := clientset.RbacV1().RoleBindings(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		snapshot, err := listResources(config, func() { _ = bar.Add(1) })
 		if err != nil {
-			log.Error().Err(err).Msg("error getting role bindings")
+			log.Error().Err(err).Msg("error listing resources")
 			return
 		}
-		_ = bar.Add(1)
 
-		clusterRoleBindings, err := clientset.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			log.Error().Err(err).Msg("error getting cluster role bindings")
-			return
-		}
+		permissions := snapshot.Collect(config)
 		_ = bar.Add(1)
 
-		permissions := collector.Collect(rolesWithPerm, clusterRolesWithPerm, roleBindings, clusterRoleBindings)
-		_ = bar.Add(1)
-
-		yamlBytes, err := yaml.Marshal(permissions)
+		documents, err := util.Documents(permissions, config)
 		if err != nil {
 			log.Error().Err(err).Msg("error marshalling permissions")
 			return
 		}
 		_ = bar.Add(1)
 
-		err = os.WriteFile("labels.yaml", yamlBytes, os.ModePerm)
+		for key, document := range documents {
+			err = os.WriteFile(key, []byte(document), os.ModePerm)
+			if err != nil {
+				break
+			}
+		}
 		_ = bar.Add(1)
 		if err != nil {
 			log.Error().Err(err).Msg("error writing permissions to file")
@@ -93,16 +77,110 @@ In cluster ConfigMap can be specified with the --cmName and --cmNamespace flags.
 		log.Info().Msg("Finished collecting permissions")
 		if cmName != "" && cmNamespace != "" {
 			log.Info().Msg("Updating ConfigMap...")
-			err := util.WriteConfigmap(clientset, permissions, util.Config{CMName: cmName, CMNamespace: cmNamespace})
+			err := util.WriteConfigmap(clientset, documents, util.Config{CMName: cmName, CMNamespace: cmNamespace, Collector: config})
 			if err != nil {
 				log.Error().Err(err).Msg("Error writing configmap")
 				return
 			}
 			log.Info().TimeDiff("duration", time.Now(), start).Msg("ConfigMap updated")
 		}
+		if report {
+			printReport(snapshot.Check())
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(runCmd)
+	runCmd.Flags().BoolVar(&report, "report", false, "print a report of dangling RoleRefs, RoleRef kind mismatches and bindings without subjects")
+}
+
+// printReport writes the binding issues as a YAML report section to stdout.
+func printReport(issues []collector.BindingIssue) {
+	if issues == nil {
+		issues = []collector.BindingIssue{}
+	}
+	encoder := yaml.NewEncoder(os.Stdout)
+	defer encoder.Close()
+	err := encoder.Encode(map[string]any{"bindingIssues": issues})
+	if err != nil {
+		log.Error().Err(err).Msg("error marshalling report")
+	}
+}
+
+// listResources lists all resources needed by the collector, calling step after each of its 9 steps.
+func listResources(config collector.Config, step func()) (collector.Snapshot, error) {
+	var s collector.Snapshot
+	var err error
+	s.Roles, err = clientset.RbacV1().Roles(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return s, fmt.Errorf("error getting roles: %w", err)
+	}
+	step()
+
+	s.ClusterRoles, err = clientset.RbacV1().ClusterRoles().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return s, fmt.Errorf("error getting cluster roles: %w", err)
+	}
+	step()
+
+	s.RoleBindings, err = clientset.RbacV1().RoleBindings(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return s, fmt.Errorf("error getting role bindings: %w", err)
+	}
+	step()
+
+	s.ClusterRoleBindings, err = clientset.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return s, fmt.Errorf("error getting cluster role bindings: %w", err)
+	}
+	step()
+
+	if config.NeedsNamespaces() {
+		s.Namespaces, err = clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return s, fmt.Errorf("error getting namespaces: %w", err)
+		}
+	}
+	step()
+
+	if config.ExpandGroups {
+		groups, err := dynamicClient.Resource(collector.OpenShiftGroupResource).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return s, fmt.Errorf("error getting groups: %w", err)
+		}
+		s.Groups = collector.OpenShiftGroups(groups)
+	}
+	step()
+
+	if config.LDAP.Enabled() {
+		resolver, err := collector.NewLDAPResolver(config.LDAP)
+		if err != nil {
+			return s, err
+		}
+		members, err := resolver.Resolve(s.BindingGroups())
+		if err != nil {
+			return s, fmt.Errorf("error resolving groups in LDAP: %w", err)
+		}
+		s.Groups = s.Groups.Merge(members)
+	}
+	step()
+
+	if config.Overrides.Enabled() {
+		s.Overrides, err = util.ReadOverrides(clientset, config.Overrides)
+		if err != nil {
+			return s, err
+		}
+	}
+	step()
+
+	if config.BreakGlass.Enabled() {
+		grants, err := util.ReadBreakGlassGrants(clientset, config.BreakGlass.ConfigMap)
+		if err != nil {
+			return s, err
+		}
+		s.BreakGlass, _ = collector.ActiveBreakGlassGrants(grants, time.Now())
+	}
+	step()
+	return s, nil
 }
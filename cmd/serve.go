@@ -4,6 +4,8 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"time"
+
 	"github.com/gepaplexx/multena-rbac-collector/server"
 	"github.com/gepaplexx/multena-rbac-collector/util"
 	"github.com/rs/zerolog"
@@ -13,8 +15,14 @@ import (
 )
 
 var (
-	level int
-	port  int
+	level  int
+	port   int
+	resync time.Duration
+	retry  = server.DefaultRetryPolicy
+	timing = server.DefaultSchedulerOptions
+	lease  = server.DefaultLeaderElectionOptions
+
+	consistencyCheck bool
 )
 
 // serveCmd represents the serve command
@@ -27,11 +35,19 @@ var serveCmd = &cobra.Command{
 		zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
 		log.Info().Int("port", port).Msg("")
 		logCommit()
+		config := loadConfig()
+		if err := retry.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("Invalid retry policy")
+		}
+		if err := lease.Validate(); err != nil {
+			log.Fatal().Err(err).Msg("Invalid leader election options")
+		}
 		initializeKubernetesClient()
 		log.Info().Msg("Starting RBAC analyzer server...")
-		server.Serve(clientset, port, util.Config{
+		server.Serve(clientset, dynamicClient, server.Options{Port: port, Resync: resync, Retry: retry, Scheduler: timing, ConsistencyCheck: consistencyCheck, LeaderElection: lease}, util.Config{
 			CMName:      cmName,
 			CMNamespace: cmNamespace,
+			Collector:   config,
 		})
 	},
 }
@@ -41,6 +57,21 @@ func init() {
 	rootCmd.AddCommand(serveCmd)
 	serveCmd.PersistentFlags().IntVarP(&level, "level", "l", 1, "Set log level between 0 and 5")
 	serveCmd.PersistentFlags().IntVarP(&port, "port", "p", 8080, "Set port to listen on")
+	serveCmd.PersistentFlags().DurationVar(&resync, "resync", 10*time.Minute, "Interval to resync the watch caches and recompute the permissions at")
+	serveCmd.PersistentFlags().DurationVar(&timing.Debounce, "debounce", timing.Debounce, "Quiet period after the last change before recomputing")
+	serveCmd.PersistentFlags().DurationVar(&timing.MinInterval, "min-interval", timing.MinInterval, "Minimum time between two recomputes")
+	serveCmd.PersistentFlags().DurationVar(&timing.MaxInterval, "max-interval", timing.MaxInterval, "Maximum time a change waits for its recompute while changes keep arriving")
+	serveCmd.PersistentFlags().DurationVar(&timing.Period, "recompute-period", timing.Period, "Interval of forced recomputes without any change, 0 disables them")
+	serveCmd.PersistentFlags().BoolVar(&consistencyCheck, "consistency-check", false, "Verify every incremental recompute against a full recompute and write the full result")
+	serveCmd.PersistentFlags().DurationVar(&retry.Initial, "retry-initial", retry.Initial, "Delay before retrying a failed list, watch or write, doubled on every failure")
+	serveCmd.PersistentFlags().DurationVar(&retry.Max, "retry-max", retry.Max, "Maximum delay between retries")
+	serveCmd.PersistentFlags().BoolVar(&lease.Enabled, "leader-elect", false, "Elect a leader among the replicas by a Lease, only the leader writes outputs")
+	serveCmd.PersistentFlags().StringVar(&lease.LeaseName, "lease-name", lease.LeaseName, "Name of the leader election Lease")
+	serveCmd.PersistentFlags().StringVar(&lease.LeaseNamespace, "lease-namespace", "", "Namespace of the leader election Lease, defaults to the namespace of the ConfigMap")
+	serveCmd.PersistentFlags().DurationVar(&lease.LeaseDuration, "lease-duration", lease.LeaseDuration, "Time followers wait before taking over a Lease that was not renewed")
+	serveCmd.PersistentFlags().DurationVar(&lease.RenewDeadline, "lease-renew-deadline", lease.RenewDeadline, "Time the leader retries renewing the Lease before giving up leadership")
+	serveCmd.PersistentFlags().DurationVar(&lease.RetryPeriod, "lease-retry-period", lease.RetryPeriod, "Interval between attempts to acquire or renew the Lease")
+	serveCmd.PersistentFlags().IntVar(&retry.Budget, "retry-budget", retry.Budget, "Failed attempts before a resource is reported degraded and a write is given up until the next recompute")
 }
 
 func updateLogLevel() {
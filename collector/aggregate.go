@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"reflect"
+
+	"github.com/rs/zerolog/log"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// AggregateClusterRoles returns the ClusterRoles with the rules of every aggregated ClusterRole replaced by
+// the rules of the ClusterRoles matched by its aggregationRule, as the clusterrole-aggregation controller does.
+// Aggregated ClusterRoles selecting each other are resolved until no rules are added anymore.
+func AggregateClusterRoles(clusterRoles v1r.ClusterRoleList) v1r.ClusterRoleList {
+	aggregated := v1r.ClusterRoleList{
+		TypeMeta: clusterRoles.TypeMeta,
+		ListMeta: clusterRoles.ListMeta,
+		Items:    make([]v1r.ClusterRole, len(clusterRoles.Items)),
+	}
+	// sources holds, per aggregated ClusterRole, the indices of the ClusterRoles it selects
+	sources := make(map[int][]int)
+	for i, clusterRole := range clusterRoles.Items {
+		aggregated.Items[i] = clusterRole
+		if clusterRole.AggregationRule == nil {
+			continue
+		}
+		aggregated.Items[i].Rules = nil
+		sources[i] = selectClusterRoles(clusterRoles.Items, i)
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := range aggregated.Items {
+			for _, source := range sources[i] {
+				for _, rule := range aggregated.Items[source].Rules {
+					if !containsRule(aggregated.Items[i].Rules, rule) {
+						aggregated.Items[i].Rules = append(aggregated.Items[i].Rules, rule)
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	return aggregated
+}
+
+// selectClusterRoles returns the indices of all other ClusterRoles matched by the aggregationRule of clusterRoles[i].
+func selectClusterRoles(clusterRoles []v1r.ClusterRole, i int) []int {
+	var selected []int
+	for _, clusterRoleSelector := range clusterRoles[i].AggregationRule.ClusterRoleSelectors {
+		selector, err := metav1.LabelSelectorAsSelector(&clusterRoleSelector)
+		if err != nil {
+			log.Warn().Err(err).Str("clusterRole", clusterRoles[i].Name).Msg("Invalid aggregation selector")
+			continue
+		}
+		for j, other := range clusterRoles {
+			if j != i && selector.Matches(labels.Set(other.Labels)) {
+				selected = append(selected, j)
+			}
+		}
+	}
+	return selected
+}
+
+func containsRule(rules []v1r.PolicyRule, rule v1r.PolicyRule) bool {
+	for _, r := range rules {
+		if reflect.DeepEqual(r, rule) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func aggregationRule(label string) *v1r.AggregationRule {
+	return &v1r.AggregationRule{
+		ClusterRoleSelectors: []metav1.LabelSelector{
+			{MatchLabels: map[string]string{label: "true"}},
+		},
+	}
+}
+
+func TestAggregateClusterRoles(t *testing.T) {
+	getPods := v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}
+	getLogs := v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}}
+
+	clusterRoles := v1r.ClusterRoleList{
+		Items: []v1r.ClusterRole{
+			{
+				ObjectMeta:      metav1.ObjectMeta{Name: "edit"},
+				AggregationRule: aggregationRule("aggregate-to-edit"),
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "view",
+					Labels: map[string]string{"aggregate-to-edit": "true"},
+				},
+				AggregationRule: aggregationRule("aggregate-to-view"),
+				Rules:           []v1r.PolicyRule{{Verbs: []string{"stale"}}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "pod-reader",
+					Labels: map[string]string{"aggregate-to-view": "true", "aggregate-to-edit": "true"},
+				},
+				Rules: []v1r.PolicyRule{getPods},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "log-reader",
+					Labels: map[string]string{"aggregate-to-view": "true"},
+				},
+				Rules: []v1r.PolicyRule{getLogs},
+			},
+			{
+				ObjectMeta:      metav1.ObjectMeta{Name: "empty"},
+				AggregationRule: aggregationRule("aggregate-to-nothing"),
+			},
+		},
+	}
+
+	aggregated := AggregateClusterRoles(clusterRoles)
+	rules := make(map[string][]v1r.PolicyRule)
+	for _, clusterRole := range aggregated.Items {
+		rules[clusterRole.Name] = clusterRole.Rules
+	}
+
+	assert.Equal(t, []v1r.PolicyRule{getPods, getLogs}, rules["view"])
+	assert.ElementsMatch(t, []v1r.PolicyRule{getPods, getLogs}, rules["edit"])
+	assert.Equal(t, []v1r.PolicyRule{getPods}, rules["pod-reader"])
+	assert.Empty(t, rules["empty"])
+	assert.Equal(t, []v1r.PolicyRule{{Verbs: []string{"stale"}}}, clusterRoles.Items[1].Rules)
+}
+
+func TestAggregateClusterRolesCycle(t *testing.T) {
+	getPods := v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}
+
+	clusterRoles := v1r.ClusterRoleList{
+		Items: []v1r.ClusterRole{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "a",
+					Labels: map[string]string{"aggregate-to-b": "true"},
+				},
+				AggregationRule: aggregationRule("aggregate-to-a"),
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "b",
+					Labels: map[string]string{"aggregate-to-a": "true"},
+				},
+				AggregationRule: aggregationRule("aggregate-to-b"),
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "pod-reader",
+					Labels: map[string]string{"aggregate-to-a": "true"},
+				},
+				Rules: []v1r.PolicyRule{getPods},
+			},
+		},
+	}
+
+	aggregated := AggregateClusterRoles(clusterRoles)
+	assert.Equal(t, []v1r.PolicyRule{getPods}, aggregated.Items[0].Rules)
+	assert.Equal(t, []v1r.PolicyRule{getPods}, aggregated.Items[1].Rules)
+}
+
+func TestGetRolesAggregated(t *testing.T) {
+	clusterRoles := v1r.ClusterRoleList{
+		Items: []v1r.ClusterRole{
+			{
+				ObjectMeta:      metav1.ObjectMeta{Name: "view"},
+				AggregationRule: aggregationRule("aggregate-to-view"),
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "pod-reader",
+					Labels: map[string]string{"aggregate-to-view": "true"},
+				},
+				Rules: []v1r.PolicyRule{{Verbs: []string{"get"}}},
+			},
+		},
+	}
+
+	_, clusterRolesWithPerm := GetRoles(v1r.RoleList{}, clusterRoles, DefaultMatcher())
+	assert.NotEmpty(t, clusterRolesWithPerm["view"])
+}
@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	v1r "k8s.io/api/rbac/v1"
+)
+
+const (
+	// BreakGlassKind is the binding kind of grants recorded for break-glass grants.
+	BreakGlassKind = "BreakGlass"
+	// DefaultBreakGlassKey is the ConfigMap key the break-glass grants are persisted in.
+	DefaultBreakGlassKey = "grants.yaml"
+	// DefaultBreakGlassMaxDuration limits the lifetime of a break-glass grant.
+	DefaultBreakGlassMaxDuration = 24 * time.Hour
+)
+
+// BreakGlassConfig enables the serve API for temporary grants. Callers authenticate with a Kubernetes bearer token
+// and must be one of AllowedUsers or a member of one of AllowedGroups. Grants are persisted in ConfigMap.
+type BreakGlassConfig struct {
+	ConfigMap     ConfigMapReference `yaml:"configMap"`
+	AllowedUsers  []string           `yaml:"allowedUsers"`
+	AllowedGroups []string           `yaml:"allowedGroups"`
+	MaxDuration   time.Duration      `yaml:"maxDuration"`
+}
+
+// Enabled reports whether break-glass grants are configured.
+func (c BreakGlassConfig) Enabled() bool {
+	return c.ConfigMap.Name != ""
+}
+
+func (c *BreakGlassConfig) compile() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.ConfigMap.Namespace == "" {
+		return fmt.Errorf("break-glass: configMap namespace must be set")
+	}
+	if len(c.AllowedUsers) == 0 && len(c.AllowedGroups) == 0 {
+		return fmt.Errorf("break-glass: allowedUsers or allowedGroups must be set")
+	}
+	if c.ConfigMap.Key == "" {
+		c.ConfigMap.Key = DefaultBreakGlassKey
+	}
+	if c.MaxDuration == 0 {
+		c.MaxDuration = DefaultBreakGlassMaxDuration
+	}
+	return nil
+}
+
+// BreakGlassGrant temporarily grants a subject access to namespaces until Expires.
+type BreakGlassGrant struct {
+	ID         string    `yaml:"id" json:"id"`
+	Kind       string    `yaml:"kind" json:"kind"`
+	Subject    string    `yaml:"subject" json:"subject"`
+	Namespaces []string  `yaml:"namespaces" json:"namespaces"`
+	Reason     string    `yaml:"reason" json:"reason"`
+	CreatedBy  string    `yaml:"createdBy" json:"createdBy"`
+	Created    time.Time `yaml:"created" json:"created"`
+	Expires    time.Time `yaml:"expires" json:"expires"`
+}
+
+// Validate reports missing or invalid fields of the grant.
+func (g BreakGlassGrant) Validate() error {
+	if g.Kind != v1r.UserKind && g.Kind != v1r.GroupKind {
+		return fmt.Errorf("break-glass: invalid kind %q, expected User or Group", g.Kind)
+	}
+	if g.Subject == "" || len(g.Namespaces) == 0 {
+		return fmt.Errorf("break-glass: a subject and namespaces are required")
+	}
+	if slices.Contains(g.Namespaces, "") {
+		return fmt.Errorf("break-glass: namespaces must not be empty")
+	}
+	if g.Reason == "" {
+		return fmt.Errorf("break-glass: a reason is required")
+	}
+	if !g.Created.Before(g.Expires) {
+		return fmt.Errorf("break-glass: grant expires before it is created")
+	}
+	return nil
+}
+
+// Expired reports whether the grant has expired at now.
+func (g BreakGlassGrant) Expired(now time.Time) bool {
+	return !now.Before(g.Expires)
+}
+
+// ParseBreakGlassGrants reads persisted break-glass grants.
+func ParseBreakGlassGrants(data []byte) ([]BreakGlassGrant, error) {
+	var grants []BreakGlassGrant
+	if err := yaml.Unmarshal(data, &grants); err != nil {
+		return nil, fmt.Errorf("break-glass: %w", err)
+	}
+	for _, grant := range grants {
+		if err := grant.Validate(); err != nil {
+			return nil, fmt.Errorf("grant %q: %w", grant.ID, err)
+		}
+	}
+	return grants, nil
+}
+
+// ActiveBreakGlassGrants splits the grants into those active and those expired at now, both sorted by expiry.
+func ActiveBreakGlassGrants(grants []BreakGlassGrant, now time.Time) (active, expired []BreakGlassGrant) {
+	for _, grant := range grants {
+		if grant.Expired(now) {
+			expired = append(expired, grant)
+		} else {
+			active = append(active, grant)
+		}
+	}
+	for _, list := range [][]BreakGlassGrant{active, expired} {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Expires.Before(list[j].Expires) })
+	}
+	return active, expired
+}
+
+// AllowBreakGlass adds the namespaces of the break-glass grants. Callers pass only active grants.
+func (p Permissions) AllowBreakGlass(grants []BreakGlassGrant) {
+	for _, grant := range grants {
+		for _, namespace := range grant.Namespaces {
+			p.add(grant.Kind, grant.Subject, namespace)
+			p.Provenance.add(grant.Kind, grant.Subject, namespace, Grant{BindingKind: BreakGlassKind, BindingName: grant.ID})
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+)
+
+func breakGlassGrant(id string, expires time.Time) BreakGlassGrant {
+	return BreakGlassGrant{
+		ID:         id,
+		Kind:       "User",
+		Subject:    "oncall",
+		Namespaces: []string{"tenant-" + id},
+		Reason:     "INC-1",
+		CreatedBy:  "admin",
+		Created:    expires.Add(-time.Hour),
+		Expires:    expires,
+	}
+}
+
+func TestActiveBreakGlassGrants(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	grants := []BreakGlassGrant{
+		breakGlassGrant("b", now.Add(2*time.Hour)),
+		breakGlassGrant("expired", now),
+		breakGlassGrant("a", now.Add(time.Hour)),
+	}
+	active, expired := ActiveBreakGlassGrants(grants, now)
+	assert.Equal(t, []BreakGlassGrant{grants[2], grants[0]}, active)
+	assert.Equal(t, []BreakGlassGrant{grants[1]}, expired)
+}
+
+func TestParseBreakGlassGrants(t *testing.T) {
+	expires := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	grants, err := ParseBreakGlassGrants([]byte(`
+- id: a
+  kind: User
+  subject: oncall
+  namespaces: [tenant-a]
+  reason: INC-1
+  createdBy: admin
+  created: 2024-01-01T11:00:00Z
+  expires: 2024-01-01T12:00:00Z
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []BreakGlassGrant{breakGlassGrant("a", expires)}, grants)
+
+	empty, err := ParseBreakGlassGrants(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+
+	_, err = ParseBreakGlassGrants([]byte(`[{id: a, kind: User, subject: oncall, namespaces: [a]}]`))
+	assert.Error(t, err)
+}
+
+func TestBreakGlassGrantValidate(t *testing.T) {
+	grant := breakGlassGrant("a", time.Now())
+	assert.NoError(t, grant.Validate())
+
+	invalid := []func(*BreakGlassGrant){
+		func(g *BreakGlassGrant) { g.Kind = "ServiceAccount" },
+		func(g *BreakGlassGrant) { g.Subject = "" },
+		func(g *BreakGlassGrant) { g.Namespaces = nil },
+		func(g *BreakGlassGrant) { g.Namespaces = []string{"a", ""} },
+		func(g *BreakGlassGrant) { g.Reason = "" },
+		func(g *BreakGlassGrant) { g.Expires = g.Created },
+	}
+	for _, modify := range invalid {
+		g := grant
+		modify(&g)
+		assert.Error(t, g.Validate())
+	}
+}
+
+func TestAllowBreakGlass(t *testing.T) {
+	permissions := NewPermissions()
+	permissions.add(v1r.UserKind, "contractor", "public")
+	grants := []BreakGlassGrant{breakGlassGrant("a", time.Now()), breakGlassGrant("b", time.Now())}
+	grants[1].Subject = "contractor"
+	grants[1].Namespaces = []string{"regulated"}
+
+	permissions.AllowBreakGlass(grants)
+	permissions.Deny(Overrides{Deny: []Override{{Name: "regulated", Kind: "User", Subject: "contractor", Namespaces: []string{"regulated"}}}})
+
+	assert.Equal(t, map[string]map[string]bool{
+		"oncall":     {"tenant-a": true},
+		"contractor": {"public": true},
+	}, permissions.Users)
+	assert.Equal(t, []Grant{{BindingKind: BreakGlassKind, BindingName: "a"}}, permissions.Provenance.Explain("oncall", true, false)["tenant-a"])
+}
+
+func TestBreakGlassConfigInvalid(t *testing.T) {
+	config := DefaultConfig()
+	config.BreakGlass = BreakGlassConfig{ConfigMap: ConfigMapReference{Name: "grants", Namespace: "multena"}}
+	assert.Error(t, config.Validate())
+
+	config.BreakGlass.AllowedGroups = []string{"oncall-admins"}
+	assert.NoError(t, config.Validate())
+	assert.Equal(t, DefaultBreakGlassKey, config.BreakGlass.ConfigMap.Key)
+	assert.Equal(t, DefaultBreakGlassMaxDuration, config.BreakGlass.MaxDuration)
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+
+	v1r "k8s.io/api/rbac/v1"
+)
+
+// BuiltinGroupMode selects how bindings to a built-in group are written.
+type BuiltinGroupMode string
+
+const (
+	// BuiltinGroupDrop drops the group.
+	BuiltinGroupDrop BuiltinGroupMode = "drop"
+	// BuiltinGroupKeep writes the group like any other group.
+	BuiltinGroupKeep BuiltinGroupMode = "group"
+	// BuiltinGroupWildcard writes a wildcard user matching all members of the group, see Wildcard.
+	BuiltinGroupWildcard BuiltinGroupMode = "wildcard"
+)
+
+// Wildcard is the user written for system:authenticated and system:unauthenticated in BuiltinGroupWildcard mode,
+// it matches every user. The ServiceAccount groups are written as system:serviceaccount:* and
+// system:serviceaccount:<namespace>:*.
+const Wildcard = "*"
+
+const (
+	// builtinGroupsExclusion counts the built-in groups dropped in the excluded log entry.
+	builtinGroupsExclusion = "builtin-groups"
+	authenticatedGroup     = "system:authenticated"
+	unauthenticatedGroup   = "system:unauthenticated"
+)
+
+// BuiltinGroups configures the groups the API server assigns to requests. Unset modes leave the groups to the
+// exclusions, which drop them by default.
+type BuiltinGroups struct {
+	Authenticated   BuiltinGroupMode `yaml:"authenticated"`
+	Unauthenticated BuiltinGroupMode `yaml:"unauthenticated"`
+	// ServiceAccounts applies to system:serviceaccounts and system:serviceaccounts:<namespace>.
+	ServiceAccounts BuiltinGroupMode `yaml:"serviceAccounts"`
+}
+
+func (b BuiltinGroups) validate() error {
+	for _, mode := range []BuiltinGroupMode{b.Authenticated, b.Unauthenticated, b.ServiceAccounts} {
+		switch mode {
+		case "", BuiltinGroupDrop, BuiltinGroupKeep, BuiltinGroupWildcard:
+		default:
+			return fmt.Errorf("unknown built-in group mode %q", mode)
+		}
+	}
+	return nil
+}
+
+// resolve returns the mode configured for a built-in group subject and the user written in wildcard mode.
+// ok is false if the subject is no built-in group or its mode is unset.
+func (b BuiltinGroups) resolve(subject v1r.Subject) (mode BuiltinGroupMode, wildcard string, ok bool) {
+	if subject.Kind != v1r.GroupKind {
+		return "", "", false
+	}
+	switch {
+	case subject.Name == authenticatedGroup:
+		mode, wildcard = b.Authenticated, Wildcard
+	case subject.Name == unauthenticatedGroup:
+		mode, wildcard = b.Unauthenticated, Wildcard
+	case subject.Name == serviceAccountGroup:
+		mode, wildcard = b.ServiceAccounts, serviceAccountUserPrefix+Wildcard
+	case strings.HasPrefix(subject.Name, serviceAccountGroup+":"):
+		namespace := strings.TrimPrefix(subject.Name, serviceAccountGroup+":")
+		mode, wildcard = b.ServiceAccounts, serviceAccountUserPrefix+namespace+":"+Wildcard
+	default:
+		return "", "", false
+	}
+	return mode, wildcard, mode != ""
+}
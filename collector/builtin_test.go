@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCollectBuiltinGroups(t *testing.T) {
+	roleBindings := v1r.RoleBindingList{Items: []v1r.RoleBinding{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "public", Namespace: "docs"},
+			RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+			Subjects: []v1r.Subject{
+				{Kind: "Group", Name: "system:authenticated"},
+				{Kind: "Group", Name: "system:unauthenticated"},
+				{Kind: "Group", Name: "system:serviceaccounts"},
+				{Kind: "Group", Name: "system:serviceaccounts:ci"},
+				{Kind: "User", Name: "alice"},
+			},
+		},
+	}}
+
+	tests := []struct {
+		name     string
+		groups   BuiltinGroups
+		expected map[string]map[string]bool
+	}{
+		{
+			name:     "default exclusions",
+			expected: map[string]map[string]bool{"alice": {"docs": true}},
+		},
+		{
+			name:   "keep as groups",
+			groups: BuiltinGroups{Authenticated: BuiltinGroupKeep, Unauthenticated: BuiltinGroupDrop, ServiceAccounts: BuiltinGroupKeep},
+			expected: map[string]map[string]bool{
+				"alice":                     {"docs": true},
+				"system:authenticated":      {"docs": true},
+				"system:serviceaccounts":    {"docs": true},
+				"system:serviceaccounts:ci": {"docs": true},
+			},
+		},
+		{
+			name:   "wildcards",
+			groups: BuiltinGroups{Authenticated: BuiltinGroupWildcard, ServiceAccounts: BuiltinGroupWildcard},
+			expected: map[string]map[string]bool{
+				"alice":                      {"docs": true},
+				"*":                          {"docs": true},
+				"system:serviceaccount:*":    {"docs": true},
+				"system:serviceaccount:ci:*": {"docs": true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.BuiltinGroups = tt.groups
+			assert.NoError(t, config.Validate())
+			permissions := Collect(RoleRules{}, RoleRules{"view": granted}, &roleBindings, &v1r.ClusterRoleBindingList{}, nil, config)
+			assert.Equal(t, tt.expected, permissions.Flat())
+		})
+	}
+
+	config := DefaultConfig()
+	config.Format = FormatSubjects
+	config.BuiltinGroups.Authenticated = BuiltinGroupWildcard
+	permissions := Collect(RoleRules{}, RoleRules{"view": granted}, &roleBindings, &v1r.ClusterRoleBindingList{}, nil, config)
+	assert.Contains(t, permissions.Users, Wildcard)
+
+	config.BuiltinGroups.Authenticated = "everyone"
+	assert.Error(t, config.Validate())
+}
@@ -1,78 +1,226 @@
 package collector
 
 import (
-	"strings"
+	"time"
 
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
 	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// ClusterWide is the namespace key of grants by ClusterRoleBindings, unless they are expanded.
+const ClusterWide = "#cluster-wide"
+
 type RBACCollect struct {
+	kind      string
 	subject   string
 	namespace string
+	grant     Grant
 }
 
-func hasPermission(rules []v1r.PolicyRule) bool {
-	for _, rule := range rules {
-		for _, verb := range rule.Verbs {
-			if verb == "*" || verb == "approve" || verb == "create" || verb == "edit" || verb == "escalate" || verb == "get" || verb == "impersonate" || verb == "list" || verb == "patch" || verb == "update" || verb == "use" || verb == "view" || verb == "watch" {
-				return true
-			}
-		}
-	}
-	return false
+// RoleRules maps evaluated roles to the rules matched by the matcher, roles without matching rules map to nil.
+type RoleRules map[string][]v1r.PolicyRule
+
+// RoleKey returns the key under which GetRoles stores a namespaced Role.
+func RoleKey(namespace, name string) string {
+	return namespace + "/" + name
 }
 
-func GetRoles(roles v1r.RoleList, clusterRoles v1r.ClusterRoleList) (map[string]bool, map[string]bool) {
-	clusterRolesWithPerm := make(map[string]bool)
-	rolesWithPerm := make(map[string]bool)
+// GetRoles evaluates every Role and ClusterRole with the given matcher. Roles are keyed by RoleKey(namespace, name),
+// ClusterRoles by their name, so equally named objects never shadow each other.
+// Aggregated ClusterRoles are resolved with AggregateClusterRoles before they are evaluated.
+func GetRoles(roles v1r.RoleList, clusterRoles v1r.ClusterRoleList, matcher Matcher) (RoleRules, RoleRules) {
+	return evaluateRoles(roles, AggregateClusterRoles(clusterRoles), matcher)
+}
+
+// evaluateRoles evaluates the roles like GetRoles, the ClusterRoles must already be aggregated.
+func evaluateRoles(roles v1r.RoleList, clusterRoles v1r.ClusterRoleList, matcher Matcher) (RoleRules, RoleRules) {
+	clusterRolesWithPerm := make(RoleRules, len(clusterRoles.Items))
+	rolesWithPerm := make(RoleRules, len(roles.Items))
 
 	for _, role := range roles.Items {
-		rolesWithPerm[role.Name] = hasPermission(role.Rules)
+		rolesWithPerm[RoleKey(role.Namespace, role.Name)] = matcher.MatchingRules(role.Rules)
 	}
 
 	for _, clusterRole := range clusterRoles.Items {
-		perm := hasPermission(clusterRole.Rules)
-		clusterRolesWithPerm[clusterRole.Name] = perm
-		rolesWithPerm[clusterRole.Name] = perm
+		clusterRolesWithPerm[clusterRole.Name] = matcher.MatchingRules(clusterRole.Rules)
 	}
 
 	return rolesWithPerm, clusterRolesWithPerm
 }
 
-func CollectOutput(out chan RBACCollect) map[string]map[string]bool {
-	permissions := make(map[string]map[string]bool, 1000)
-	for o := range out {
-		if _, ok := permissions[o.subject]; !ok {
-			permissions[o.subject] = make(map[string]bool, 1000)
+// refRules resolves a RoleRef of a binding in the given namespace against exactly the object it references
+// and returns its matching rules. ClusterRoleBindings pass an empty namespace, as they may only reference ClusterRoles.
+func refRules(ref v1r.RoleRef, namespace string, roles, clusterRoles RoleRules) []v1r.PolicyRule {
+	switch ref.Kind {
+	case "ClusterRole":
+		return clusterRoles[ref.Name]
+	case "Role":
+		if namespace == "" {
+			return nil
 		}
-		permissions[o.subject][o.namespace] = true
+		return roles[RoleKey(namespace, ref.Name)]
+	default:
+		return nil
+	}
+}
+
+func CollectOutput(out chan RBACCollect) Permissions {
+	permissions := NewPermissions()
+	for o := range out {
+		permissions.add(o.kind, o.subject, o.namespace)
+		permissions.Provenance.add(o.kind, o.subject, o.namespace, o.grant)
 	}
 	return permissions
 }
 
-func Collect(roles, clusterRoles map[string]bool, roleBindings *v1r.RoleBindingList, clusterRoleBindings *v1r.ClusterRoleBindingList) map[string]map[string]bool {
+// Collect evaluates all bindings against the evaluated roles and returns the namespaces each subject may access.
+// ClusterRoleBindings grant access to the ClusterWide namespace or, with config.ExpandClusterWide, to every selected namespace.
+// Binding subjects matched by one of the configured exclusions are dropped, built-in groups are handled
+// according to config.BuiltinGroups instead if a mode is set for them.
+// namespaces must be set if config.NeedsNamespaces, RoleBindings in namespaces not selected by config.Namespaces are ignored.
+// With config.Tenants, namespaces are written as their tenant values.
+// Bindings whose config.ExpiryAnnotation lies in the past are ignored.
+func Collect(roles, clusterRoles RoleRules, roleBindings *v1r.RoleBindingList, clusterRoleBindings *v1r.ClusterRoleBindingList, namespaces *v1.NamespaceList, config Config) Permissions {
 	out := make(chan RBACCollect, 1000)
-	for _, rb := range roleBindings.Items {
-		if roles[rb.RoleRef.Name] {
-			for _, subject := range rb.Subjects {
-				if subject.Kind == "ServiceAccount" || rb.Namespace == "openshift-console-user-settings" || strings.Contains(subject.Name, "system") {
-					continue
-				}
-				out <- RBACCollect{subject: subject.Name, namespace: rb.Namespace}
-			}
+	c := newCollection(config, namespaces)
+	c.emit = func(o RBACCollect) { out <- o }
+	go func() {
+		for i := range roleBindings.Items {
+			c.collectRoleBinding(&roleBindings.Items[i], roles, clusterRoles)
+		}
+		for i := range clusterRoleBindings.Items {
+			c.collectClusterRoleBinding(&clusterRoleBindings.Items[i], roles, clusterRoles)
+		}
+		close(out)
+	}()
+	permissions := CollectOutput(out)
+	if len(c.excluded) > 0 {
+		log.Info().Interface("excluded", c.excluded).Msg("Excluded binding subjects")
+	}
+	if c.expired > 0 {
+		log.Info().Int("expired", c.expired).Msg("Ignored expired bindings")
+	}
+	return permissions
+}
+
+// collection holds the state of a single Collect call.
+type collection struct {
+	emit       func(RBACCollect)
+	config     Config
+	exclusions []Exclusion
+	// excluded counts the dropped binding subjects per exclusion
+	excluded map[string]int
+	// tenants maps namespaces to tenant values if a tenant mapping is configured
+	tenants map[string]string
+	// selected holds the selected namespaces if a namespace selection is configured
+	selected map[string]bool
+	// clusterWide are the namespaces granted by ClusterRoleBindings
+	clusterWide []string
+	// at is the time bindings are checked for expiry at, expired counts the ignored bindings
+	at      time.Time
+	expired int
+}
+
+// newCollection prepares collecting bindings, namespaces must be set if config.NeedsNamespaces.
+func newCollection(config Config, namespaces *v1.NamespaceList) *collection {
+	c := &collection{
+		config:      config,
+		exclusions:  config.exclusions(),
+		excluded:    make(map[string]int),
+		clusterWide: []string{ClusterWide},
+		at:          now(),
+	}
+	if config.Tenants.Enabled() {
+		c.tenants = config.Tenants.Tenants(namespaces)
+	}
+	if config.Namespaces.Enabled() {
+		c.selected = config.Namespaces.SelectNamespaces(namespaces)
+	}
+	if config.ExpandClusterWide {
+		c.clusterWide = c.clusterWide[:0]
+		for namespace := range config.Namespaces.SelectNamespaces(namespaces) {
+			c.clusterWide = append(c.clusterWide, namespace)
 		}
 	}
+	return c
+}
 
-	for _, crb := range clusterRoleBindings.Items {
-		if clusterRoles[crb.RoleRef.Name] {
-			for _, subject := range crb.Subjects {
-				if subject.Kind == "ServiceAccount" || strings.Contains(subject.Name, "system") {
-					continue
-				}
-				out <- RBACCollect{subject: subject.Name, namespace: "#cluster-wide"}
+// collectRoleBinding emits the subjects of a RoleBinding unless its namespace is not selected or it expired.
+func (c *collection) collectRoleBinding(rb *v1r.RoleBinding, roles, clusterRoles RoleRules) {
+	if c.selected != nil && !c.selected[rb.Namespace] || c.isExpired("RoleBinding", rb.ObjectMeta) {
+		return
+	}
+	if rules := refRules(rb.RoleRef, rb.Namespace, roles, clusterRoles); len(rules) > 0 {
+		grant := newGrant("RoleBinding", rb.Name, rb.Namespace, rb.RoleRef, rules)
+		c.collectSubjects(rb.Subjects, grant, []string{rb.Namespace})
+	}
+}
+
+// collectClusterRoleBinding emits the subjects of a ClusterRoleBinding unless it expired.
+func (c *collection) collectClusterRoleBinding(crb *v1r.ClusterRoleBinding, roles, clusterRoles RoleRules) {
+	if c.isExpired("ClusterRoleBinding", crb.ObjectMeta) {
+		return
+	}
+	if rules := refRules(crb.RoleRef, "", roles, clusterRoles); len(rules) > 0 {
+		grant := newGrant("ClusterRoleBinding", crb.Name, "", crb.RoleRef, rules)
+		c.collectSubjects(crb.Subjects, grant, c.clusterWide)
+	}
+}
+
+// collectSubjects emits every subject of a binding that is not excluded for each of the granted namespaces.
+func (c *collection) collectSubjects(subjects []v1r.Subject, grant Grant, namespaces []string) {
+	bindingNamespace, bindingName := grant.BindingNamespace, grant.BindingName
+	for _, subject := range subjects {
+		kind, name, excluded, ok := c.config.resolveSubject(c.exclusions, subject, bindingNamespace, bindingName)
+		if excluded != "" {
+			c.excluded[excluded]++
+			continue
+		}
+		if !ok {
+			log.Debug().Str("binding", bindingName).Str("serviceAccount", subject.Name).Msg("ServiceAccount subject without namespace")
+			continue
+		}
+		for _, namespace := range namespaces {
+			if namespace, ok := c.tenant(namespace); ok {
+				c.emit(RBACCollect{kind: kind, subject: name, namespace: namespace, grant: grant})
 			}
 		}
 	}
-	close(out)
-	return CollectOutput(out)
+}
+
+// tenant translates a namespace into its tenant value, ClusterWide is kept as is.
+func (c *collection) tenant(namespace string) (string, bool) {
+	if c.tenants == nil || namespace == ClusterWide {
+		return namespace, true
+	}
+	if tenant, ok := c.tenants[namespace]; ok {
+		return tenant, true
+	}
+	return namespace, c.config.Tenants.Fallback != TenantFallbackDrop
+}
+
+// isExpired reports whether the binding expired according to the expiry annotation. Bindings with an
+// invalid expiry are kept, as the Kubernetes authorizer still honours them.
+func (c *collection) isExpired(kind string, meta metav1.ObjectMeta) bool {
+	expiry, ok, err := bindingExpiry(meta, c.config.ExpiryAnnotation)
+	if err != nil {
+		log.Warn().Err(err).Str("kind", kind).Str("namespace", meta.Namespace).Str("name", meta.Name).Msg("Invalid binding expiry")
+	}
+	if ok && !c.at.Before(expiry) {
+		c.expired++
+		return true
+	}
+	return false
+}
+
+// matchExclusion returns the first exclusion matching the binding subject, or nil.
+func matchExclusion(exclusions []Exclusion, subject v1r.Subject, namespace, bindingName string) *Exclusion {
+	for i := range exclusions {
+		if exclusions[i].Matches(subject, namespace, bindingName) {
+			return &exclusions[i]
+		}
+	}
+	return nil
 }
@@ -8,20 +8,23 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func TestHasPermission(t *testing.T) {
+// granted stands in for the matching rules of a role with permission
+var granted = []v1r.PolicyRule{{Verbs: []string{"get"}}}
+
+func TestDefaultMatcher(t *testing.T) {
 	rules := []v1r.PolicyRule{
 		{
 			Verbs: []string{"get", "list"},
 		},
 	}
-	assert.True(t, hasPermission(rules))
+	assert.True(t, DefaultMatcher().Matches(rules))
 
 	rules = []v1r.PolicyRule{
 		{
 			Verbs: []string{"delete"},
 		},
 	}
-	assert.False(t, hasPermission(rules))
+	assert.False(t, DefaultMatcher().Matches(rules))
 }
 
 func TestGetRoles(t *testing.T) {
@@ -29,7 +32,8 @@ func TestGetRoles(t *testing.T) {
 		Items: []v1r.Role{
 			{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: "testRole",
+					Name:      "testRole",
+					Namespace: "testNamespace",
 				},
 				Rules: []v1r.PolicyRule{
 					{
@@ -55,38 +59,41 @@ func TestGetRoles(t *testing.T) {
 		},
 	}
 
-	rolesWithPerm, clusterRolesWithPerm := GetRoles(roleList, clusterRoleList)
-	assert.True(t, rolesWithPerm["testRole"])
-	assert.False(t, clusterRolesWithPerm["testClusterRole"])
+	rolesWithPerm, clusterRolesWithPerm := GetRoles(roleList, clusterRoleList, DefaultMatcher())
+	assert.NotEmpty(t, rolesWithPerm[RoleKey("testNamespace", "testRole")])
+	assert.Empty(t, clusterRolesWithPerm["testClusterRole"])
 }
 
 func TestCollectOutput(t *testing.T) {
 	out := make(chan RBACCollect, 1000)
-	out <- RBACCollect{subject: "test", namespace: "namespace1"}
-	out <- RBACCollect{subject: "test", namespace: "namespace2"}
+	out <- RBACCollect{kind: "User", subject: "test", namespace: "namespace1"}
+	out <- RBACCollect{kind: "User", subject: "test", namespace: "namespace2"}
+	out <- RBACCollect{kind: "Group", subject: "test", namespace: "namespace3"}
 	close(out)
 
 	perms := CollectOutput(out)
-	assert.True(t, perms["test"]["namespace1"])
-	assert.True(t, perms["test"]["namespace2"])
+	assert.True(t, perms.Users["test"]["namespace1"])
+	assert.True(t, perms.Users["test"]["namespace2"])
+	assert.False(t, perms.Users["test"]["namespace3"])
+	assert.True(t, perms.Groups["test"]["namespace3"])
 }
 
 func TestCollect(t *testing.T) {
 	tests := []struct {
 		name                string
-		roles               map[string]bool
-		clusterRoles        map[string]bool
+		roles               RoleRules
+		clusterRoles        RoleRules
 		roleBindings        v1r.RoleBindingList
 		clusterRoleBindings v1r.ClusterRoleBindingList
 		expectedOutput      map[string]map[string]bool
 	}{
 		{
 			name: "basic test",
-			roles: map[string]bool{
-				"testRole": true,
+			roles: RoleRules{
+				RoleKey("testNamespace", "testRole"): granted,
 			},
-			clusterRoles: map[string]bool{
-				"testClusterRole": true,
+			clusterRoles: RoleRules{
+				"testClusterRole": granted,
 			},
 			roleBindings: v1r.RoleBindingList{
 				Items: []v1r.RoleBinding{
@@ -96,6 +103,7 @@ func TestCollect(t *testing.T) {
 							Namespace: "testNamespace",
 						},
 						RoleRef: v1r.RoleRef{
+							Kind: "Role",
 							Name: "testRole",
 						},
 						Subjects: []v1r.Subject{
@@ -114,6 +122,7 @@ func TestCollect(t *testing.T) {
 							Name: "testClusterRoleBinding",
 						},
 						RoleRef: v1r.RoleRef{
+							Kind: "ClusterRole",
 							Name: "testClusterRole",
 						},
 						Subjects: []v1r.Subject{
@@ -136,10 +145,10 @@ func TestCollect(t *testing.T) {
 		},
 		{
 			name: "role without permission",
-			roles: map[string]bool{
-				"noPermissionRole": false,
+			roles: RoleRules{
+				RoleKey("testNamespace", "noPermissionRole"): nil,
 			},
-			clusterRoles: map[string]bool{},
+			clusterRoles: RoleRules{},
 			roleBindings: v1r.RoleBindingList{
 				Items: []v1r.RoleBinding{
 					{
@@ -148,6 +157,7 @@ func TestCollect(t *testing.T) {
 							Namespace: "testNamespace",
 						},
 						RoleRef: v1r.RoleRef{
+							Kind: "Role",
 							Name: "noPermissionRole",
 						},
 						Subjects: []v1r.Subject{
@@ -164,10 +174,10 @@ func TestCollect(t *testing.T) {
 		},
 		{
 			name: "system user in roleBinding",
-			roles: map[string]bool{
-				"testRole": true,
+			roles: RoleRules{
+				RoleKey("testNamespace", "testRole"): granted,
 			},
-			clusterRoles: map[string]bool{},
+			clusterRoles: RoleRules{},
 			roleBindings: v1r.RoleBindingList{
 				Items: []v1r.RoleBinding{
 					{
@@ -176,6 +186,7 @@ func TestCollect(t *testing.T) {
 							Namespace: "testNamespace",
 						},
 						RoleRef: v1r.RoleRef{
+							Kind: "Role",
 							Name: "testRole",
 						},
 						Subjects: []v1r.Subject{
@@ -192,9 +203,9 @@ func TestCollect(t *testing.T) {
 		},
 		{
 			name:  "service account in clusterRoleBinding",
-			roles: map[string]bool{},
-			clusterRoles: map[string]bool{
-				"testClusterRole": true,
+			roles: RoleRules{},
+			clusterRoles: RoleRules{
+				"testClusterRole": granted,
 			},
 			roleBindings: v1r.RoleBindingList{},
 			clusterRoleBindings: v1r.ClusterRoleBindingList{
@@ -204,6 +215,7 @@ func TestCollect(t *testing.T) {
 							Name: "testClusterRoleBinding",
 						},
 						RoleRef: v1r.RoleRef{
+							Kind: "ClusterRole",
 							Name: "testClusterRole",
 						},
 						Subjects: []v1r.Subject{
@@ -219,9 +231,9 @@ func TestCollect(t *testing.T) {
 		},
 		{
 			name:  "cluster-wide permission",
-			roles: map[string]bool{},
-			clusterRoles: map[string]bool{
-				"testClusterRole": true,
+			roles: RoleRules{},
+			clusterRoles: RoleRules{
+				"testClusterRole": granted,
 			},
 			roleBindings: v1r.RoleBindingList{},
 			clusterRoleBindings: v1r.ClusterRoleBindingList{
@@ -231,6 +243,7 @@ func TestCollect(t *testing.T) {
 							Name: "testClusterRoleBinding",
 						},
 						RoleRef: v1r.RoleRef{
+							Kind: "ClusterRole",
 							Name: "testClusterRole",
 						},
 						Subjects: []v1r.Subject{
@@ -250,10 +263,10 @@ func TestCollect(t *testing.T) {
 		},
 		{
 			name: "Multiple users in a single roleBinding",
-			roles: map[string]bool{
-				"multiUserRole": true,
+			roles: RoleRules{
+				RoleKey("multiUserNamespace", "multiUserRole"): granted,
 			},
-			clusterRoles: map[string]bool{},
+			clusterRoles: RoleRules{},
 			roleBindings: v1r.RoleBindingList{
 				Items: []v1r.RoleBinding{
 					{
@@ -262,6 +275,7 @@ func TestCollect(t *testing.T) {
 							Namespace: "multiUserNamespace",
 						},
 						RoleRef: v1r.RoleRef{
+							Kind: "Role",
 							Name: "multiUserRole",
 						},
 						Subjects: []v1r.Subject{
@@ -289,10 +303,10 @@ func TestCollect(t *testing.T) {
 		},
 		{
 			name: "RoleBinding with ServiceAccount and non-System User",
-			roles: map[string]bool{
-				"mixedRole": true,
+			roles: RoleRules{
+				RoleKey("mixedNamespace", "mixedRole"): granted,
 			},
-			clusterRoles: map[string]bool{},
+			clusterRoles: RoleRules{},
 			roleBindings: v1r.RoleBindingList{
 				Items: []v1r.RoleBinding{
 					{
@@ -301,6 +315,7 @@ func TestCollect(t *testing.T) {
 							Namespace: "mixedNamespace",
 						},
 						RoleRef: v1r.RoleRef{
+							Kind: "Role",
 							Name: "mixedRole",
 						},
 						Subjects: []v1r.Subject{
@@ -325,10 +340,10 @@ func TestCollect(t *testing.T) {
 		},
 		{
 			name: "RoleBinding with non-system service account",
-			roles: map[string]bool{
-				"saRole": true,
+			roles: RoleRules{
+				RoleKey("saNamespace", "saRole"): granted,
 			},
-			clusterRoles: map[string]bool{},
+			clusterRoles: RoleRules{},
 			roleBindings: v1r.RoleBindingList{
 				Items: []v1r.RoleBinding{
 					{
@@ -337,6 +352,7 @@ func TestCollect(t *testing.T) {
 							Namespace: "saNamespace",
 						},
 						RoleRef: v1r.RoleRef{
+							Kind: "Role",
 							Name: "saRole",
 						},
 						Subjects: []v1r.Subject{
@@ -351,11 +367,92 @@ func TestCollect(t *testing.T) {
 			clusterRoleBindings: v1r.ClusterRoleBindingList{},
 			expectedOutput:      map[string]map[string]bool{},
 		},
+		{
+			name: "equally named roles in different namespaces",
+			roles: RoleRules{
+				RoleKey("namespaceA", "viewer"): nil,
+				RoleKey("namespaceB", "viewer"): granted,
+			},
+			clusterRoles: RoleRules{},
+			roleBindings: v1r.RoleBindingList{
+				Items: []v1r.RoleBinding{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "viewerBinding",
+							Namespace: "namespaceA",
+						},
+						RoleRef: v1r.RoleRef{
+							Kind: "Role",
+							Name: "viewer",
+						},
+						Subjects: []v1r.Subject{
+							{
+								Kind: "User",
+								Name: "userA",
+							},
+						},
+					},
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "viewerBinding",
+							Namespace: "namespaceB",
+						},
+						RoleRef: v1r.RoleRef{
+							Kind: "Role",
+							Name: "viewer",
+						},
+						Subjects: []v1r.Subject{
+							{
+								Kind: "User",
+								Name: "userB",
+							},
+						},
+					},
+				},
+			},
+			clusterRoleBindings: v1r.ClusterRoleBindingList{},
+			expectedOutput: map[string]map[string]bool{
+				"userB": {
+					"namespaceB": true,
+				},
+			},
+		},
+		{
+			name: "roleBinding referencing clusterRole shadowed by local role",
+			roles: RoleRules{
+				RoleKey("testNamespace", "edit"): granted,
+			},
+			clusterRoles: RoleRules{
+				"edit": nil,
+			},
+			roleBindings: v1r.RoleBindingList{
+				Items: []v1r.RoleBinding{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "editBinding",
+							Namespace: "testNamespace",
+						},
+						RoleRef: v1r.RoleRef{
+							Kind: "ClusterRole",
+							Name: "edit",
+						},
+						Subjects: []v1r.Subject{
+							{
+								Kind: "User",
+								Name: "testUser",
+							},
+						},
+					},
+				},
+			},
+			clusterRoleBindings: v1r.ClusterRoleBindingList{},
+			expectedOutput:      map[string]map[string]bool{},
+		},
 		{
 			name:  "clusterRole with no associated bindings",
-			roles: map[string]bool{},
-			clusterRoles: map[string]bool{
-				"orphanClusterRole": true,
+			roles: RoleRules{},
+			clusterRoles: RoleRules{
+				"orphanClusterRole": granted,
 			},
 			roleBindings:        v1r.RoleBindingList{},
 			clusterRoleBindings: v1r.ClusterRoleBindingList{},
@@ -365,8 +462,8 @@ func TestCollect(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := Collect(tt.roles, tt.clusterRoles, &tt.roleBindings, &tt.clusterRoleBindings)
-			assert.Equal(t, tt.expectedOutput, result)
+			result := Collect(tt.roles, tt.clusterRoles, &tt.roleBindings, &tt.clusterRoleBindings, nil, DefaultConfig())
+			assert.Equal(t, tt.expectedOutput, result.Flat())
 		})
 	}
 }
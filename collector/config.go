@@ -0,0 +1,135 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+
+	v1r "k8s.io/api/rbac/v1"
+)
+
+// profileName restricts profile names to characters valid in ConfigMap keys.
+var profileName = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// Config configures how the collector evaluates RBAC objects.
+type Config struct {
+	Matcher Matcher `yaml:"matcher"`
+	// Profiles are additional matchers, e.g. for metrics, logs and traces, each producing its own permissions.
+	Profiles map[string]Matcher `yaml:"profiles"`
+	Format   Format             `yaml:"format"`
+	// Exclusions default to DefaultExclusions if unset, an empty list disables all exclusions.
+	Exclusions []Exclusion `yaml:"exclusions"`
+	// BuiltinGroups configures the handling of system:authenticated, system:unauthenticated and the ServiceAccount groups.
+	BuiltinGroups BuiltinGroups `yaml:"builtinGroups"`
+	// ServiceAccounts emits ServiceAccount subjects as users named system:serviceaccount:<namespace>:<name>.
+	ServiceAccounts bool               `yaml:"serviceAccounts"`
+	Namespaces      NamespaceSelection `yaml:"namespaces"`
+	// ExpandClusterWide writes ClusterRoleBinding grants for every selected namespace instead of ClusterWide.
+	ExpandClusterWide bool `yaml:"expandClusterWide"`
+	// Provenance additionally writes the grants of every subject and namespace.
+	Provenance bool `yaml:"provenance"`
+	// Tenants translates namespaces into tenant label values in the output.
+	Tenants TenantMapping `yaml:"tenants"`
+	// ExpandGroups additionally grants the members of OpenShift Groups the namespaces of their groups.
+	ExpandGroups bool `yaml:"expandGroups"`
+	// LDAP resolves the members of Group subjects by LDAP searches, they are granted the namespaces of their groups.
+	LDAP LDAPConfig `yaml:"ldap"`
+	// Overrides locates a document of static allow and deny entries merged into the permissions.
+	Overrides OverridesSource `yaml:"overrides"`
+	// ExpiryAnnotation names a binding annotation holding an RFC 3339 timestamp after which the binding is ignored.
+	ExpiryAnnotation string `yaml:"expiryAnnotation"`
+	// Impersonation adds the namespaces subjects may access by impersonating other subjects as indirect entries.
+	Impersonation bool `yaml:"impersonation"`
+	// BreakGlass enables temporary grants managed through the serve API.
+	BreakGlass BreakGlassConfig `yaml:"breakGlass"`
+}
+
+// DefaultConfig returns the configuration used when no config file is given.
+func DefaultConfig() Config {
+	return Config{
+		Matcher: DefaultMatcher(),
+		Format:  FormatFlat,
+	}
+}
+
+// NeedsNamespaces reports whether Collect must be given the Namespace objects of the cluster.
+func (c Config) NeedsNamespaces() bool {
+	return c.Namespaces.Enabled() || c.ExpandClusterWide || c.Tenants.Enabled()
+}
+
+// ResolvesGroups reports whether the members of groups are granted the namespaces of their groups,
+// resolved from OpenShift Groups or LDAP.
+func (c Config) ResolvesGroups() bool {
+	return c.ExpandGroups || c.LDAP.Enabled()
+}
+
+func (c Config) exclusions() []Exclusion {
+	if c.Exclusions == nil {
+		return DefaultExclusions(c.ServiceAccounts)
+	}
+	return c.Exclusions
+}
+
+// resolveSubject returns the kind and name a binding subject is written as. Built-in groups with a configured
+// mode bypass the exclusions, ServiceAccounts are users in ServiceAccounts mode. excluded names the exclusion
+// dropping the subject, ok is false for dropped subjects and ServiceAccounts without namespace.
+func (c Config) resolveSubject(exclusions []Exclusion, subject v1r.Subject, bindingNamespace, bindingName string) (kind, name, excluded string, ok bool) {
+	kind, name = subject.Kind, subject.Name
+	if mode, wildcard, builtin := c.BuiltinGroups.resolve(subject); builtin {
+		switch mode {
+		case BuiltinGroupDrop:
+			return "", "", builtinGroupsExclusion, false
+		case BuiltinGroupWildcard:
+			kind, name = v1r.UserKind, wildcard
+		}
+	} else if exclusion := matchExclusion(exclusions, subject, bindingNamespace, bindingName); exclusion != nil && !exclusion.Include {
+		return "", "", exclusion.Name, false
+	}
+	if kind == v1r.ServiceAccountKind && c.ServiceAccounts {
+		kind, name = v1r.UserKind, serviceAccountUsername(subject, bindingNamespace)
+		if name == "" {
+			return "", "", "", false
+		}
+	}
+	return kind, name, "", true
+}
+
+// subjectName resolves a binding subject like Collect, ServiceAccounts outside ServiceAccounts mode are skipped.
+func (c Config) subjectName(exclusions []Exclusion, subject v1r.Subject, bindingNamespace, bindingName string) (kind, name string, ok bool) {
+	kind, name, _, ok = c.resolveSubject(exclusions, subject, bindingNamespace, bindingName)
+	return kind, name, ok && kind != v1r.ServiceAccountKind
+}
+
+// Validate reports the first invalid setting of the configuration and compiles its patterns.
+func (c *Config) Validate() error {
+	switch c.Format {
+	case "", FormatFlat, FormatSubjects:
+	default:
+		return fmt.Errorf("unknown output format %q", c.Format)
+	}
+	for name := range c.Profiles {
+		if !profileName.MatchString(name) {
+			return fmt.Errorf("invalid profile name %q", name)
+		}
+	}
+	if err := c.BuiltinGroups.validate(); err != nil {
+		return err
+	}
+	for i := range c.Exclusions {
+		if err := c.Exclusions[i].compile(); err != nil {
+			return err
+		}
+	}
+	if err := c.BreakGlass.compile(); err != nil {
+		return err
+	}
+	if err := c.Overrides.compile(); err != nil {
+		return err
+	}
+	if err := c.LDAP.compile(); err != nil {
+		return err
+	}
+	if err := c.Tenants.compile(); err != nil {
+		return err
+	}
+	return c.Namespaces.compile()
+}
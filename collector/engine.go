@@ -0,0 +1,547 @@
+package collector
+
+import (
+	"cmp"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// bindingKey identifies a RoleBinding or ClusterRoleBinding, namespace is empty for ClusterRoleBindings.
+type bindingKey struct {
+	kind, namespace, name string
+}
+
+// entryKey identifies a namespace of a subject in the permissions.
+type entryKey struct {
+	kind, subject, namespace string
+}
+
+// grantKey identifies the grant of a binding for an entry, see compareGrants.
+type grantKey struct {
+	entryKey
+	bindingKind, bindingNamespace, bindingName string
+}
+
+// profileState holds the permissions of one profile collected from the bindings, before overrides, break-glass
+// grants, group expansion and impersonation are applied.
+type profileState struct {
+	matcher      Matcher
+	roles        RoleRules
+	clusterRoles RoleRules
+	permissions  Permissions
+	// contributions holds what each binding added, entries and grants count the bindings adding them
+	contributions map[bindingKey][]RBACCollect
+	entries       map[entryKey]int
+	grants        map[grantKey]int
+}
+
+func (s *profileState) add(o RBACCollect) {
+	entry := entryKey{kind: o.kind, subject: o.subject, namespace: o.namespace}
+	if s.entries[entry]++; s.entries[entry] == 1 {
+		s.permissions.add(o.kind, o.subject, o.namespace)
+	}
+	grant := grantKey{entryKey: entry, bindingKind: o.grant.BindingKind, bindingNamespace: o.grant.BindingNamespace, bindingName: o.grant.BindingName}
+	if s.grants[grant]++; s.grants[grant] == 1 {
+		s.permissions.Provenance.add(o.kind, o.subject, o.namespace, o.grant)
+	}
+}
+
+func (s *profileState) remove(o RBACCollect) {
+	entry := entryKey{kind: o.kind, subject: o.subject, namespace: o.namespace}
+	grant := grantKey{entryKey: entry, bindingKind: o.grant.BindingKind, bindingNamespace: o.grant.BindingNamespace, bindingName: o.grant.BindingName}
+	if s.grants[grant]--; s.grants[grant] == 0 {
+		delete(s.grants, grant)
+		s.permissions.Provenance.remove(o.kind, o.subject, o.namespace, o.grant)
+	}
+	if s.entries[entry]--; s.entries[entry] == 0 {
+		delete(s.entries, entry)
+		s.permissions.remove(o.kind, o.subject, o.namespace)
+	}
+}
+
+// Engine maintains the permissions of every profile incrementally. Changed objects are recorded with the Set and
+// Delete methods, Collect then evaluates only the changed roles and re-collects only the bindings referencing them,
+// the changed bindings and, after namespace changes, the bindings of namespaces whose selection or tenant changed.
+// Every binding's contribution is reference counted per subject and namespace, so removing it keeps entries other
+// bindings grant as well.
+// The engine keeps the objects passed to it, callers must not modify them afterwards. It is not safe for
+// concurrent use.
+type Engine struct {
+	config   Config
+	profiles map[string]*profileState
+
+	roles               map[string]*v1r.Role
+	clusterRoles        map[string]*v1r.ClusterRole
+	roleBindings        map[string]*v1r.RoleBinding
+	clusterRoleBindings map[string]*v1r.ClusterRoleBinding
+	namespaces          map[string]*v1.Namespace
+	// aggregated holds the rules of the ClusterRoles after aggregation
+	aggregated map[string][]v1r.PolicyRule
+	collection *collection
+
+	// roleBindingIndex maps Role/<namespace>/<name> and ClusterRole/<name> to the bindings referencing them,
+	// namespaceIndex namespaces to their RoleBindings and refs every binding to its role key
+	roleBindingIndex map[string]map[bindingKey]bool
+	namespaceIndex   map[string]map[bindingKey]bool
+	refs             map[bindingKey]string
+	// expiries holds the bindings with an expiry, expired whether they were expired when last collected
+	expiries map[bindingKey]time.Time
+	expired  map[bindingKey]bool
+	// excluded holds the subjects each binding dropped per exclusion in the default profile, excludedTotal their sum
+	excluded      map[bindingKey]map[string]int
+	excludedTotal map[string]int
+
+	// snapshot and issues are computed from the recorded objects on demand and kept until the next change
+	snapshot *Snapshot
+	issues   []BindingIssue
+	checked  bool
+
+	changedRoles        map[string]bool
+	changedClusterRoles bool
+	changedBindings     map[bindingKey]bool
+	changedNamespaces   bool
+}
+
+// NewEngine creates an engine for the default matcher and the profiles of the configuration.
+func NewEngine(config Config) *Engine {
+	e := &Engine{
+		config:              config,
+		profiles:            make(map[string]*profileState, len(config.Profiles)+1),
+		roles:               make(map[string]*v1r.Role),
+		clusterRoles:        make(map[string]*v1r.ClusterRole),
+		roleBindings:        make(map[string]*v1r.RoleBinding),
+		clusterRoleBindings: make(map[string]*v1r.ClusterRoleBinding),
+		namespaces:          make(map[string]*v1.Namespace),
+		aggregated:          make(map[string][]v1r.PolicyRule),
+		collection:          newCollection(config, &v1.NamespaceList{}),
+		roleBindingIndex:    make(map[string]map[bindingKey]bool),
+		namespaceIndex:      make(map[string]map[bindingKey]bool),
+		refs:                make(map[bindingKey]string),
+		expiries:            make(map[bindingKey]time.Time),
+		expired:             make(map[bindingKey]bool),
+		excluded:            make(map[bindingKey]map[string]int),
+		excludedTotal:       make(map[string]int),
+		changedRoles:        make(map[string]bool),
+		changedBindings:     make(map[bindingKey]bool),
+	}
+	matchers := map[string]Matcher{DefaultProfile: config.Matcher}
+	for name, matcher := range config.Profiles {
+		matchers[name] = matcher
+	}
+	for name, matcher := range matchers {
+		e.profiles[name] = &profileState{
+			matcher:       matcher,
+			roles:         make(RoleRules),
+			clusterRoles:  make(RoleRules),
+			permissions:   NewPermissions(),
+			contributions: make(map[bindingKey][]RBACCollect),
+			entries:       make(map[entryKey]int),
+			grants:        make(map[grantKey]int),
+		}
+	}
+	return e
+}
+
+func (e *Engine) SetRole(role *v1r.Role) {
+	e.invalidate()
+	key := RoleKey(role.Namespace, role.Name)
+	e.roles[key] = role
+	e.changedRoles[key] = true
+}
+
+func (e *Engine) DeleteRole(namespace, name string) {
+	e.invalidate()
+	key := RoleKey(namespace, name)
+	delete(e.roles, key)
+	e.changedRoles[key] = true
+}
+
+func (e *Engine) SetClusterRole(clusterRole *v1r.ClusterRole) {
+	e.invalidate()
+	e.clusterRoles[clusterRole.Name] = clusterRole
+	e.changedClusterRoles = true
+}
+
+func (e *Engine) DeleteClusterRole(name string) {
+	e.invalidate()
+	delete(e.clusterRoles, name)
+	e.changedClusterRoles = true
+}
+
+func (e *Engine) SetRoleBinding(rb *v1r.RoleBinding) {
+	e.invalidate()
+	e.roleBindings[RoleKey(rb.Namespace, rb.Name)] = rb
+	e.changedBindings[bindingKey{kind: "RoleBinding", namespace: rb.Namespace, name: rb.Name}] = true
+}
+
+func (e *Engine) DeleteRoleBinding(namespace, name string) {
+	e.invalidate()
+	delete(e.roleBindings, RoleKey(namespace, name))
+	e.changedBindings[bindingKey{kind: "RoleBinding", namespace: namespace, name: name}] = true
+}
+
+func (e *Engine) SetClusterRoleBinding(crb *v1r.ClusterRoleBinding) {
+	e.invalidate()
+	e.clusterRoleBindings[crb.Name] = crb
+	e.changedBindings[bindingKey{kind: "ClusterRoleBinding", name: crb.Name}] = true
+}
+
+func (e *Engine) DeleteClusterRoleBinding(name string) {
+	e.invalidate()
+	delete(e.clusterRoleBindings, name)
+	e.changedBindings[bindingKey{kind: "ClusterRoleBinding", name: name}] = true
+}
+
+// SetNamespace records a namespace, namespaces are ignored unless Config.NeedsNamespaces.
+func (e *Engine) SetNamespace(namespace *v1.Namespace) {
+	e.invalidate()
+	e.namespaces[namespace.Name] = namespace
+	e.changedNamespaces = true
+}
+
+func (e *Engine) DeleteNamespace(name string) {
+	e.invalidate()
+	delete(e.namespaces, name)
+	e.changedNamespaces = true
+}
+
+// invalidate drops the snapshot and issues of the previously recorded objects.
+func (e *Engine) invalidate() {
+	e.snapshot, e.issues, e.checked = nil, nil, false
+}
+
+// Snapshot returns the recorded objects sorted by namespace and name, Namespaces is only set if
+// Config.NeedsNamespaces. The lists are shared with the engine and kept until the next change, they must not
+// be modified.
+func (e *Engine) Snapshot() Snapshot {
+	if e.snapshot != nil {
+		return *e.snapshot
+	}
+	s := Snapshot{
+		Roles:               &v1r.RoleList{Items: make([]v1r.Role, 0, len(e.roles))},
+		ClusterRoles:        &v1r.ClusterRoleList{Items: make([]v1r.ClusterRole, 0, len(e.clusterRoles))},
+		RoleBindings:        &v1r.RoleBindingList{Items: make([]v1r.RoleBinding, 0, len(e.roleBindings))},
+		ClusterRoleBindings: &v1r.ClusterRoleBindingList{Items: make([]v1r.ClusterRoleBinding, 0, len(e.clusterRoleBindings))},
+	}
+	for _, key := range sortedKeys(e.roles) {
+		s.Roles.Items = append(s.Roles.Items, *e.roles[key])
+	}
+	for _, key := range sortedKeys(e.clusterRoles) {
+		s.ClusterRoles.Items = append(s.ClusterRoles.Items, *e.clusterRoles[key])
+	}
+	for _, key := range sortedKeys(e.roleBindings) {
+		s.RoleBindings.Items = append(s.RoleBindings.Items, *e.roleBindings[key])
+	}
+	for _, key := range sortedKeys(e.clusterRoleBindings) {
+		s.ClusterRoleBindings.Items = append(s.ClusterRoleBindings.Items, *e.clusterRoleBindings[key])
+	}
+	if e.config.NeedsNamespaces() {
+		s.Namespaces = e.namespaceList()
+	}
+	e.snapshot = &s
+	return s
+}
+
+// Check reports the binding issues of the recorded objects like Snapshot.Check, they are only checked again
+// after a change.
+func (e *Engine) Check() []BindingIssue {
+	if !e.checked {
+		e.issues, e.checked = e.Snapshot().Check(), true
+	}
+	return e.issues
+}
+
+// NextExpiry returns the next time a recorded binding expires, false if none does. It covers the bindings
+// collected by the last Collect.
+func (e *Engine) NextExpiry() (time.Time, bool) {
+	var next time.Time
+	found := false
+	at := now()
+	for _, expiry := range e.expiries {
+		if expiry.After(at) && (!found || expiry.Before(next)) {
+			next, found = expiry, true
+		}
+	}
+	return next, found
+}
+
+// Excluded returns the binding subjects dropped per exclusion in the default profile by the last Collect.
+func (e *Engine) Excluded() map[string]int {
+	return maps.Clone(e.excludedTotal)
+}
+
+func (e *Engine) namespaceList() *v1.NamespaceList {
+	list := &v1.NamespaceList{Items: make([]v1.Namespace, 0, len(e.namespaces))}
+	for _, key := range sortedKeys(e.namespaces) {
+		list.Items = append(list.Items, *e.namespaces[key])
+	}
+	return list
+}
+
+// Collect applies the recorded changes and returns the permissions of every profile like Snapshot.Collect.
+// The group members, overrides and break-glass grants are taken from s, which must be a Snapshot of the engine
+// for impersonation. The returned permissions are copies the caller may modify.
+func (e *Engine) Collect(s Snapshot) map[string]Permissions {
+	e.update()
+	if len(e.excludedTotal) > 0 {
+		log.Info().Interface("excluded", e.excludedTotal).Msg("Excluded binding subjects")
+	}
+	if expired := e.countExpired(); expired > 0 {
+		log.Info().Int("expired", expired).Msg("Ignored expired bindings")
+	}
+	var impersonators []*impersonator
+	if e.config.Impersonation {
+		impersonators = s.findImpersonators(AggregateClusterRoles(*s.ClusterRoles), e.config)
+	}
+	permissions := make(map[string]Permissions, len(e.profiles))
+	for name, state := range e.profiles {
+		p := state.permissions.clone()
+		s.adjust(p, e.config, impersonators)
+		permissions[name] = p
+	}
+	return permissions
+}
+
+// countExpired returns the number of bindings ignored as expired by the last Collect.
+func (e *Engine) countExpired() int {
+	expired := 0
+	for _, ok := range e.expired {
+		if ok {
+			expired++
+		}
+	}
+	return expired
+}
+
+// update evaluates the changed roles and re-collects the affected bindings.
+func (e *Engine) update() {
+	e.collection.at = now()
+	e.collection.expired = 0
+	affected := e.changedBindings
+	e.changedBindings = make(map[bindingKey]bool)
+
+	if e.changedNamespaces && e.config.NeedsNamespaces() {
+		previous := e.collection
+		e.collection = newCollection(e.config, e.namespaceList())
+		e.collection.at = previous.at
+		changed, clusterWideChanged := changedNamespaces(previous, e.collection)
+		for namespace := range changed {
+			for key := range e.namespaceIndex[namespace] {
+				affected[key] = true
+			}
+		}
+		// expanded ClusterRoleBindings grant every selected namespace under its tenant
+		if clusterWideChanged || e.config.ExpandClusterWide && len(changed) > 0 {
+			for name := range e.clusterRoleBindings {
+				affected[bindingKey{kind: "ClusterRoleBinding", name: name}] = true
+			}
+		}
+	}
+	e.changedNamespaces = false
+
+	if e.changedClusterRoles {
+		list := v1r.ClusterRoleList{Items: make([]v1r.ClusterRole, 0, len(e.clusterRoles))}
+		for _, key := range sortedKeys(e.clusterRoles) {
+			list.Items = append(list.Items, *e.clusterRoles[key])
+		}
+		aggregated := make(map[string][]v1r.PolicyRule, len(list.Items))
+		for _, clusterRole := range AggregateClusterRoles(list).Items {
+			aggregated[clusterRole.Name] = clusterRole.Rules
+		}
+		for name := range e.aggregated {
+			if _, ok := aggregated[name]; !ok {
+				e.clusterRoleChanged(name, nil, false, affected)
+			}
+		}
+		for name, rules := range aggregated {
+			if previous, ok := e.aggregated[name]; !ok || !reflect.DeepEqual(previous, rules) {
+				e.clusterRoleChanged(name, rules, true, affected)
+			}
+		}
+		e.aggregated = aggregated
+		e.changedClusterRoles = false
+	}
+
+	for key := range e.changedRoles {
+		role, ok := e.roles[key]
+		for _, state := range e.profiles {
+			if ok {
+				state.roles[key] = state.matcher.MatchingRules(role.Rules)
+			} else {
+				delete(state.roles, key)
+			}
+		}
+		for binding := range e.roleBindingIndex["Role/"+key] {
+			affected[binding] = true
+		}
+	}
+	e.changedRoles = make(map[string]bool)
+
+	// bindings expiring since they were collected
+	for key, expiry := range e.expiries {
+		if !e.collection.at.Before(expiry) != e.expired[key] {
+			affected[key] = true
+		}
+	}
+
+	for key := range affected {
+		e.collectBinding(key)
+	}
+}
+
+func (e *Engine) clusterRoleChanged(name string, rules []v1r.PolicyRule, ok bool, affected map[bindingKey]bool) {
+	for _, state := range e.profiles {
+		if ok {
+			state.clusterRoles[name] = state.matcher.MatchingRules(rules)
+		} else {
+			delete(state.clusterRoles, name)
+		}
+	}
+	for binding := range e.roleBindingIndex["ClusterRole/"+name] {
+		affected[binding] = true
+	}
+}
+
+// collectBinding replaces the contribution of the binding in every profile and updates the indexes.
+func (e *Engine) collectBinding(key bindingKey) {
+	for _, state := range e.profiles {
+		for _, o := range state.contributions[key] {
+			state.remove(o)
+		}
+		delete(state.contributions, key)
+	}
+	for exclusion, count := range e.excluded[key] {
+		if e.excludedTotal[exclusion] -= count; e.excludedTotal[exclusion] == 0 {
+			delete(e.excludedTotal, exclusion)
+		}
+	}
+	delete(e.excluded, key)
+	e.unindex(key)
+
+	var collect func(state *profileState)
+	switch key.kind {
+	case "RoleBinding":
+		rb, ok := e.roleBindings[RoleKey(key.namespace, key.name)]
+		if !ok {
+			return
+		}
+		e.index(key, roleKey(rb.RoleRef, rb.Namespace), rb.ObjectMeta)
+		collect = func(state *profileState) { e.collection.collectRoleBinding(rb, state.roles, state.clusterRoles) }
+	case "ClusterRoleBinding":
+		crb, ok := e.clusterRoleBindings[key.name]
+		if !ok {
+			return
+		}
+		e.index(key, roleKey(crb.RoleRef, ""), crb.ObjectMeta)
+		collect = func(state *profileState) {
+			e.collection.collectClusterRoleBinding(crb, state.roles, state.clusterRoles)
+		}
+	}
+
+	for name, state := range e.profiles {
+		var contribution []RBACCollect
+		e.collection.emit = func(o RBACCollect) { contribution = append(contribution, o) }
+		e.collection.excluded = make(map[string]int)
+		collect(state)
+		for _, o := range contribution {
+			state.add(o)
+		}
+		if len(contribution) > 0 {
+			state.contributions[key] = contribution
+		}
+		if name == DefaultProfile && len(e.collection.excluded) > 0 {
+			e.excluded[key] = e.collection.excluded
+			for exclusion, count := range e.collection.excluded {
+				e.excludedTotal[exclusion] += count
+			}
+		}
+	}
+	e.collection.emit = nil
+}
+
+func (e *Engine) index(key bindingKey, role string, meta metav1.ObjectMeta) {
+	e.refs[key] = role
+	if e.roleBindingIndex[role] == nil {
+		e.roleBindingIndex[role] = make(map[bindingKey]bool)
+	}
+	e.roleBindingIndex[role][key] = true
+	if key.kind == "RoleBinding" {
+		if e.namespaceIndex[key.namespace] == nil {
+			e.namespaceIndex[key.namespace] = make(map[bindingKey]bool)
+		}
+		e.namespaceIndex[key.namespace][key] = true
+	}
+	if expiry, ok, _ := bindingExpiry(meta, e.config.ExpiryAnnotation); ok {
+		e.expiries[key] = expiry
+		e.expired[key] = !e.collection.at.Before(expiry)
+	}
+}
+
+func (e *Engine) unindex(key bindingKey) {
+	if role, ok := e.refs[key]; ok {
+		delete(e.roleBindingIndex[role], key)
+		if len(e.roleBindingIndex[role]) == 0 {
+			delete(e.roleBindingIndex, role)
+		}
+		delete(e.refs, key)
+	}
+	delete(e.namespaceIndex[key.namespace], key)
+	if len(e.namespaceIndex[key.namespace]) == 0 {
+		delete(e.namespaceIndex, key.namespace)
+	}
+	delete(e.expiries, key)
+	delete(e.expired, key)
+}
+
+// roleKey returns the key of the role a RoleRef references in roleBindingIndex.
+func roleKey(ref v1r.RoleRef, namespace string) string {
+	if ref.Kind == "Role" {
+		return "Role/" + RoleKey(namespace, ref.Name)
+	}
+	return ref.Kind + "/" + ref.Name
+}
+
+// changedNamespaces returns the namespaces whose selection or tenant differs between the collections and whether
+// the namespaces granted by ClusterRoleBindings differ.
+func changedNamespaces(previous, current *collection) (map[string]bool, bool) {
+	changed := make(map[string]bool)
+	for _, selected := range []map[string]bool{previous.selected, current.selected} {
+		for namespace := range selected {
+			if previous.selected[namespace] != current.selected[namespace] {
+				changed[namespace] = true
+			}
+		}
+	}
+	for _, tenants := range []map[string]string{previous.tenants, current.tenants} {
+		for namespace := range tenants {
+			previousTenant, previousOK := previous.tenants[namespace]
+			currentTenant, currentOK := current.tenants[namespace]
+			if previousTenant != currentTenant || previousOK != currentOK {
+				changed[namespace] = true
+			}
+		}
+	}
+	return changed, !slices.Equal(sortedStrings(previous.clusterWide), sortedStrings(current.clusterWide))
+}
+
+func sortedStrings(values []string) []string {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, cmp.Compare[string])
+	return keys
+}
@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEngineMatchesFullCollect(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+	defer func() { now = time.Now }()
+
+	getPods := []v1r.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}}
+	deletePods := []v1r.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"delete"}}}
+	user := func(name string) v1r.Subject { return v1r.Subject{Kind: v1r.UserKind, Name: name} }
+
+	tenants := DefaultConfig()
+	tenants.Tenants = TenantMapping{Label: "tenant"}
+	tenants.Namespaces = NamespaceSelection{Exclude: []string{"kube-*"}}
+	tenants.ExpiryAnnotation = expiryAnnotation
+	tenants.Profiles = map[string]Matcher{"delete": {Permissions: []Permission{{APIGroup: "", Resource: "pods", Verb: "delete"}}}}
+	expanded := DefaultConfig()
+	expanded.ExpandClusterWide = true
+	expanded.Provenance = true
+
+	for name, config := range map[string]Config{"tenants": tenants, "expanded": expanded} {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, config.Validate())
+			e := NewEngine(config)
+			check := func(step string) {
+				s := e.Snapshot()
+				assert.Equal(t, s.Check(), e.Check(), step)
+				assert.Equal(t, s.Collect(config), e.Collect(s), step)
+				next, ok := e.NextExpiry()
+				expectedNext, expectedOK := s.NextExpiry(config)
+				assert.Equal(t, expectedOK, ok, step)
+				assert.Equal(t, expectedNext, next, step)
+				assert.Equal(t, map[string]int{"system-subjects": 1}, e.Excluded(), step)
+			}
+
+			namespaces := []v1.Namespace{
+				namespace("a", nil),
+				namespace("b", map[string]string{"tenant": "t1"}),
+				namespace("kube-system", nil),
+			}
+			for i := range namespaces {
+				e.SetNamespace(&namespaces[i])
+			}
+			e.SetClusterRole(&v1r.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "view"}, Rules: getPods})
+			e.SetClusterRole(&v1r.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "edit"}, AggregationRule: aggregationRule("aggregate-to-edit")})
+			e.SetRole(&v1r.Role{ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "a"}, Rules: getPods})
+			reader := roleBinding("a", "reader", "reader", user("bob"))
+			reader.RoleRef.Kind = "Role"
+			roleBindings := []v1r.RoleBinding{
+				roleBinding("a", "view", "view", user("alice")),
+				roleBinding("a", "system", "view", user("system:admin")),
+				reader,
+				roleBinding("b", "view", "view", user("alice"), v1r.Subject{Kind: v1r.GroupKind, Name: "devs"}),
+				roleBinding("b", "view-again", "view", user("alice")),
+				roleBinding("kube-system", "view", "view", user("alice")),
+				expiringRoleBinding("b", "2024-01-01T13:00:00Z"),
+			}
+			for i := range roleBindings {
+				e.SetRoleBinding(&roleBindings[i])
+			}
+			e.SetClusterRoleBinding(&v1r.ClusterRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "editors"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "edit"},
+				Subjects:   []v1r.Subject{user("carol")},
+			})
+			check("initial")
+			check("unchanged")
+			assert.Empty(t, e.Collect(e.Snapshot())[DefaultProfile].Users["carol"], "edit aggregates no rules yet")
+
+			e.SetClusterRole(&v1r.ClusterRole{
+				ObjectMeta: metav1.ObjectMeta{Name: "pods", Labels: map[string]string{"aggregate-to-edit": "true"}},
+				Rules:      append(append([]v1r.PolicyRule{}, getPods...), deletePods...),
+			})
+			check("aggregated ClusterRole changed")
+			assert.NotEmpty(t, e.Collect(e.Snapshot())[DefaultProfile].Users["carol"])
+
+			e.SetRole(&v1r.Role{ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "a"}, Rules: deletePods})
+			check("Role changed")
+
+			e.DeleteRoleBinding("b", "view")
+			check("RoleBinding deleted, alice keeps b by view-again")
+			assert.True(t, e.Collect(e.Snapshot())[DefaultProfile].Users["alice"][map[string]string{"tenants": "t1", "expanded": "b"}[name]])
+
+			c := namespace("c", map[string]string{"tenant": "t1"})
+			e.SetNamespace(&c)
+			cView := roleBinding("c", "view", "view", user("dave"))
+			e.SetRoleBinding(&cView)
+			check("namespace added")
+
+			b := namespace("b", map[string]string{"tenant": "t2"})
+			e.SetNamespace(&b)
+			e.DeleteNamespace("kube-system")
+			check("namespaces changed")
+
+			at = at.Add(2 * time.Hour)
+			check("binding expired")
+
+			e.DeleteClusterRole("pods")
+			e.DeleteClusterRoleBinding("editors")
+			check("ClusterRole and ClusterRoleBinding deleted")
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package collector
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	v1r "k8s.io/api/rbac/v1"
+)
+
+// EscalationPathKind names how a subject could grant itself access.
+type EscalationPathKind string
+
+const (
+	// EscalationBind is the ability to create RoleBindings to roles the subject may bind.
+	EscalationBind EscalationPathKind = "bind"
+	// EscalationEscalate is the ability to write roles with permissions the subject does not hold, either by updating
+	// roles (including those bound to the subject) or by creating and binding them.
+	EscalationEscalate EscalationPathKind = "escalate"
+)
+
+// Escalation is a subject without telemetry access to a namespace that could obtain it through RBAC self-service.
+// Namespace is ClusterWide for abilities granted by ClusterRoleBindings.
+type Escalation struct {
+	Namespace string             `yaml:"namespace" json:"namespace"`
+	Kind      string             `yaml:"kind" json:"kind"`
+	Subject   string             `yaml:"subject" json:"subject"`
+	Path      EscalationPathKind `yaml:"path" json:"path"`
+	// Roles are the bindable roles granting access, for EscalationBind.
+	Roles []string `yaml:"roles,omitempty" json:"roles,omitempty"`
+	// Bindings are the bindings granting the abilities, as Kind/[namespace/]name.
+	Bindings []string `yaml:"bindings" json:"bindings"`
+}
+
+// rbacAbilities are the RBAC self-service abilities of a subject in one scope.
+type rbacAbilities struct {
+	writeBindings bool
+	createRoles   bool
+	updateRoles   bool
+	escalate      bool
+	// bindAll is set if every role may be bound, bind holds the names of bindable roles otherwise
+	bindAll  bool
+	bind     map[string]bool
+	bindings map[string]bool
+}
+
+func (a *rbacAbilities) merge(other *rbacAbilities) {
+	a.writeBindings = a.writeBindings || other.writeBindings
+	a.createRoles = a.createRoles || other.createRoles
+	a.updateRoles = a.updateRoles || other.updateRoles
+	a.escalate = a.escalate || other.escalate
+	a.bindAll = a.bindAll || other.bindAll
+	for name := range other.bind {
+		a.bind[name] = true
+	}
+	for binding := range other.bindings {
+		a.bindings[binding] = true
+	}
+}
+
+func newRBACAbilities() *rbacAbilities {
+	return &rbacAbilities{bind: make(map[string]bool), bindings: make(map[string]bool)}
+}
+
+// addRule records the abilities a rule grants, it reports whether there were any.
+func (a *rbacAbilities) addRule(rule v1r.PolicyRule, clusterScope bool) bool {
+	if !slices.Contains(rule.APIGroups, v1r.GroupName) && !slices.Contains(rule.APIGroups, v1r.APIGroupAll) {
+		return false
+	}
+	verb := func(verbs ...string) bool {
+		for _, v := range verbs {
+			if slices.Contains(rule.Verbs, v) {
+				return true
+			}
+		}
+		return slices.Contains(rule.Verbs, v1r.VerbAll)
+	}
+	resource := func(resources ...string) bool {
+		for _, r := range resources {
+			if slices.Contains(rule.Resources, r) {
+				return true
+			}
+		}
+		return slices.Contains(rule.Resources, v1r.ResourceAll)
+	}
+	bindingResources := []string{"rolebindings"}
+	roleResources := []string{"roles"}
+	if clusterScope {
+		bindingResources = append(bindingResources, "clusterrolebindings")
+		roleResources = append(roleResources, "clusterroles")
+	}
+	found := false
+	// resourceNames do not restrict create, update and patch only apply to existing objects
+	if resource(bindingResources...) && (verb("create") || len(rule.ResourceNames) == 0 && verb("update", "patch")) {
+		a.writeBindings, found = true, true
+	}
+	if resource(roleResources...) && verb("create") {
+		a.createRoles, found = true, true
+	}
+	if resource(roleResources...) && len(rule.ResourceNames) == 0 && verb("update", "patch") {
+		a.updateRoles, found = true, true
+	}
+	if resource("roles", "clusterroles") && verb("escalate") {
+		a.escalate, found = true, true
+	}
+	if resource("roles", "clusterroles") && verb("bind") {
+		found = true
+		if len(rule.ResourceNames) == 0 {
+			a.bindAll = true
+		}
+		for _, name := range rule.ResourceNames {
+			a.bind[name] = true
+		}
+	}
+	return found
+}
+
+// escalationScope is a subject in a namespace or ClusterWide.
+type escalationScope struct {
+	namespace, kind, subject string
+}
+
+// Escalations lists, per namespace, the subjects without access according to the default profile's matcher
+// that could grant it to themselves: by binding a role granting access (bind and writing RoleBindings), or by
+// writing roles with any permission (escalate and updating roles, or escalate, creating roles and binding any role).
+// Writing RoleBindings alone is no escalation, the API server only allows binding roles whose permissions the
+// subject already holds.
+// Abilities granted by ClusterRoleBindings apply to every namespace and are reported for ClusterWide.
+func (s Snapshot) Escalations(config Config) []Escalation {
+	clusterRoles := AggregateClusterRoles(*s.ClusterRoles)
+	granting, clusterGranting := evaluateRoles(*s.Roles, clusterRoles, config.Matcher)
+	permissions := s.Collect(config)[DefaultProfile]
+	exclusions := config.exclusions()
+
+	abilities := make(map[escalationScope]*rbacAbilities)
+	record := func(scope escalationScope, rules []v1r.PolicyRule, binding string) {
+		a := newRBACAbilities()
+		found := false
+		for _, rule := range rules {
+			found = a.addRule(rule, scope.namespace == ClusterWide) || found
+		}
+		if !found {
+			return
+		}
+		a.bindings[binding] = true
+		if existing, ok := abilities[scope]; ok {
+			existing.merge(a)
+		} else {
+			abilities[scope] = a
+		}
+	}
+	subjects := func(subjects []v1r.Subject, bindingNamespace, bindingName string) []escalationScope {
+		var scopes []escalationScope
+		for _, subject := range subjects {
+			if kind, name, ok := config.subjectName(exclusions, subject, bindingNamespace, bindingName); ok {
+				scopes = append(scopes, escalationScope{kind: kind, subject: name})
+			}
+		}
+		return scopes
+	}
+
+	roles, clusterRoleRules := evaluateRoles(*s.Roles, clusterRoles, Matcher{Permissions: []Permission{{APIGroup: v1r.GroupName, Resource: v1r.ResourceAll, Verb: v1r.VerbAll}}})
+	for _, rb := range s.RoleBindings.Items {
+		rules := refRules(rb.RoleRef, rb.Namespace, roles, clusterRoleRules)
+		for _, scope := range subjects(rb.Subjects, rb.Namespace, rb.Name) {
+			scope.namespace = rb.Namespace
+			record(scope, rules, "RoleBinding/"+rb.Namespace+"/"+rb.Name)
+		}
+	}
+	for _, crb := range s.ClusterRoleBindings.Items {
+		rules := refRules(crb.RoleRef, "", roles, clusterRoleRules)
+		for _, scope := range subjects(crb.Subjects, "", crb.Name) {
+			scope.namespace = ClusterWide
+			record(scope, rules, "ClusterRoleBinding/"+crb.Name)
+		}
+	}
+
+	// abilities granted cluster-wide also apply in every namespace
+	for scope, a := range abilities {
+		if scope.namespace == ClusterWide {
+			continue
+		}
+		if clusterWide, ok := abilities[escalationScope{namespace: ClusterWide, kind: scope.kind, subject: scope.subject}]; ok {
+			a.merge(clusterWide)
+		}
+	}
+
+	// the permissions are keyed by output values, i.e. tenants with a tenant mapping
+	c := newCollection(config, s.Namespaces)
+	var escalations []Escalation
+	for scope, a := range abilities {
+		subjects := permissions.Users
+		if scope.kind == v1r.GroupKind {
+			subjects = permissions.Groups
+		}
+		if c.hasAccess(subjects[scope.subject], scope.namespace) {
+			continue
+		}
+		bindings := sortedKeys(a.bindings)
+		if roles := bindableRoles(a, scope.namespace, granting, clusterGranting); a.writeBindings && len(roles) > 0 {
+			escalations = append(escalations, Escalation{
+				Namespace: scope.namespace, Kind: scope.kind, Subject: scope.subject,
+				Path: EscalationBind, Roles: roles, Bindings: bindings,
+			})
+		}
+		if a.escalate && (a.updateRoles || a.createRoles && a.writeBindings && a.bindAll) {
+			escalations = append(escalations, Escalation{
+				Namespace: scope.namespace, Kind: scope.kind, Subject: scope.subject,
+				Path: EscalationEscalate, Bindings: bindings,
+			})
+		}
+	}
+	slices.SortFunc(escalations, func(a, b Escalation) int {
+		if c := cmp.Compare(a.Namespace, b.Namespace); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Kind, b.Kind); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Subject, b.Subject); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Path, b.Path)
+	})
+	return escalations
+}
+
+// hasAccess reports whether the granted namespaces already cover the namespace or ClusterWide. With
+// ExpandClusterWide, ClusterWide is covered by access to every namespace ClusterRoleBindings are expanded to.
+func (c *collection) hasAccess(granted map[string]bool, namespace string) bool {
+	if granted[ClusterWide] {
+		return true
+	}
+	if namespace != ClusterWide || !c.config.ExpandClusterWide {
+		tenant, ok := c.tenant(namespace)
+		return ok && granted[tenant]
+	}
+	for _, namespace := range c.clusterWide {
+		if tenant, ok := c.tenant(namespace); ok && !granted[tenant] {
+			return false
+		}
+	}
+	return true
+}
+
+// bindableRoles returns the roles granting access the abilities allow to bind in the namespace,
+// as ClusterRole/name or Role/name.
+func bindableRoles(a *rbacAbilities, namespace string, roles, clusterRoles RoleRules) []string {
+	var bindable []string
+	for name, rules := range clusterRoles {
+		if len(rules) > 0 && (a.bindAll || a.bind[name]) {
+			bindable = append(bindable, "ClusterRole/"+name)
+		}
+	}
+	if namespace != ClusterWide {
+		for key, rules := range roles {
+			ns, name, _ := strings.Cut(key, "/")
+			if ns == namespace && len(rules) > 0 && (a.bindAll || a.bind[name]) {
+				bindable = append(bindable, "Role/"+name)
+			}
+		}
+	}
+	slices.Sort(bindable)
+	return bindable
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func rbacRule(resources []string, verbs []string, names ...string) v1r.PolicyRule {
+	return v1r.PolicyRule{APIGroups: []string{v1r.GroupName}, Resources: resources, Verbs: verbs, ResourceNames: names}
+}
+
+func TestSnapshotEscalations(t *testing.T) {
+	user := func(name string) v1r.Subject { return v1r.Subject{Kind: "User", Name: name} }
+	view := []v1r.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}}
+
+	snapshot := Snapshot{
+		Roles: &v1r.RoleList{Items: []v1r.Role{
+			{ObjectMeta: metav1.ObjectMeta{Name: "pod-reader", Namespace: "tenant-a"}, Rules: view},
+			{ObjectMeta: metav1.ObjectMeta{Name: "binder", Namespace: "tenant-a"}, Rules: []v1r.PolicyRule{
+				rbacRule([]string{"rolebindings"}, []string{"create"}),
+				rbacRule([]string{"roles"}, []string{"bind"}, "pod-reader"),
+			}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "role-admin", Namespace: "tenant-a"}, Rules: []v1r.PolicyRule{
+				rbacRule([]string{"roles"}, []string{"escalate", "update"}),
+			}},
+		}},
+		ClusterRoles: &v1r.ClusterRoleList{Items: []v1r.ClusterRole{
+			{ObjectMeta: metav1.ObjectMeta{Name: "view"}, Rules: view},
+			{ObjectMeta: metav1.ObjectMeta{Name: "binding-creator"}, Rules: []v1r.PolicyRule{rbacRule([]string{"rolebindings"}, []string{"create"})}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "view-binder"}, Rules: []v1r.PolicyRule{rbacRule([]string{"clusterroles"}, []string{"bind"}, "view")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "rbac-admin"}, Rules: []v1r.PolicyRule{rbacRule([]string{"*"}, []string{"*"})}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"}, Rules: []v1r.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}}}},
+		}},
+		RoleBindings: &v1r.RoleBindingList{Items: []v1r.RoleBinding{
+			// bind and create rolebindings in the same role
+			{ObjectMeta: metav1.ObjectMeta{Name: "binder", Namespace: "tenant-a"}, RoleRef: v1r.RoleRef{Kind: "Role", Name: "binder"}, Subjects: []v1r.Subject{user("alice")}},
+			// create rolebindings only, no escalation
+			{ObjectMeta: metav1.ObjectMeta{Name: "creator", Namespace: "tenant-b"}, RoleRef: v1r.RoleRef{Kind: "ClusterRole", Name: "binding-creator"}, Subjects: []v1r.Subject{user("bob"), user("carol")}},
+			// escalate and update roles
+			{ObjectMeta: metav1.ObjectMeta{Name: "role-admin", Namespace: "tenant-a"}, RoleRef: v1r.RoleRef{Kind: "Role", Name: "role-admin"}, Subjects: []v1r.Subject{user("dave")}},
+			// already has access
+			{ObjectMeta: metav1.ObjectMeta{Name: "viewer", Namespace: "tenant-a"}, RoleRef: v1r.RoleRef{Kind: "Role", Name: "pod-reader"}, Subjects: []v1r.Subject{user("dave")}},
+		}},
+		ClusterRoleBindings: &v1r.ClusterRoleBindingList{Items: []v1r.ClusterRoleBinding{
+			// bind from a ClusterRoleBinding combined with create rolebindings in tenant-b
+			{ObjectMeta: metav1.ObjectMeta{Name: "view-binder"}, RoleRef: v1r.RoleRef{Kind: "ClusterRole", Name: "view-binder"}, Subjects: []v1r.Subject{user("carol")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "rbac-admins"}, RoleRef: v1r.RoleRef{Kind: "ClusterRole", Name: "rbac-admin"}, Subjects: []v1r.Subject{{Kind: "Group", Name: "rbac-admins"}}},
+			// already has access everywhere
+			{ObjectMeta: metav1.ObjectMeta{Name: "root"}, RoleRef: v1r.RoleRef{Kind: "ClusterRole", Name: "cluster-admin"}, Subjects: []v1r.Subject{user("root")}},
+		}},
+	}
+
+	config := DefaultConfig()
+	config.Matcher = Matcher{Permissions: []Permission{{APIGroup: "", Resource: "pods", Verb: "get"}}}
+	escalations := snapshot.Escalations(config)
+
+	assert.Equal(t, []Escalation{
+		{Namespace: ClusterWide, Kind: "Group", Subject: "rbac-admins", Path: EscalationBind, Roles: []string{"ClusterRole/cluster-admin", "ClusterRole/view"}, Bindings: []string{"ClusterRoleBinding/rbac-admins"}},
+		{Namespace: ClusterWide, Kind: "Group", Subject: "rbac-admins", Path: EscalationEscalate, Bindings: []string{"ClusterRoleBinding/rbac-admins"}},
+		{Namespace: "tenant-a", Kind: "User", Subject: "alice", Path: EscalationBind, Roles: []string{"Role/pod-reader"}, Bindings: []string{"RoleBinding/tenant-a/binder"}},
+		{Namespace: "tenant-b", Kind: "User", Subject: "carol", Path: EscalationBind, Roles: []string{"ClusterRole/view"}, Bindings: []string{"ClusterRoleBinding/view-binder", "RoleBinding/tenant-b/creator"}},
+	}, escalations)
+
+	// existing access is looked up under the tenant of the namespace
+	snapshot.Namespaces = &v1.NamespaceList{Items: []v1.Namespace{
+		namespace("tenant-a", map[string]string{"tenant": "a"}),
+		namespace("tenant-b", map[string]string{"tenant": "b"}),
+	}}
+	config.Tenants = TenantMapping{Label: "tenant"}
+	assert.Equal(t, escalations, snapshot.Escalations(config))
+
+	// expanded ClusterRoleBindings grant access to every namespace instead of ClusterWide
+	config.ExpandClusterWide = true
+	assert.Equal(t, escalations, snapshot.Escalations(config))
+	config.Tenants = TenantMapping{}
+	assert.Equal(t, escalations, snapshot.Escalations(config))
+}
+
+func TestSnapshotEscalationsBuiltinGroups(t *testing.T) {
+	view := []v1r.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}}
+	snapshot := Snapshot{
+		Roles: &v1r.RoleList{Items: []v1r.Role{
+			{ObjectMeta: metav1.ObjectMeta{Name: "pod-reader", Namespace: "tenant-a"}, Rules: view},
+			{ObjectMeta: metav1.ObjectMeta{Name: "binder", Namespace: "tenant-a"}, Rules: []v1r.PolicyRule{
+				rbacRule([]string{"rolebindings"}, []string{"create"}),
+				rbacRule([]string{"roles"}, []string{"bind"}, "pod-reader"),
+			}},
+		}},
+		ClusterRoles: &v1r.ClusterRoleList{},
+		RoleBindings: &v1r.RoleBindingList{Items: []v1r.RoleBinding{
+			{ObjectMeta: metav1.ObjectMeta{Name: "binder", Namespace: "tenant-a"}, RoleRef: v1r.RoleRef{Kind: "Role", Name: "binder"}, Subjects: []v1r.Subject{{Kind: "Group", Name: "system:authenticated"}}},
+		}},
+		ClusterRoleBindings: &v1r.ClusterRoleBindingList{},
+	}
+	config := DefaultConfig()
+	config.Matcher = Matcher{Permissions: []Permission{{APIGroup: "", Resource: "pods", Verb: "get"}}}
+	assert.Empty(t, snapshot.Escalations(config), "built-in groups are excluded by default")
+
+	config.BuiltinGroups.Authenticated = BuiltinGroupKeep
+	assert.Equal(t, []Escalation{
+		{Namespace: "tenant-a", Kind: "Group", Subject: "system:authenticated", Path: EscalationBind, Roles: []string{"Role/pod-reader"}, Bindings: []string{"RoleBinding/tenant-a/binder"}},
+	}, snapshot.Escalations(config))
+
+	config.BuiltinGroups.Authenticated = BuiltinGroupWildcard
+	assert.Equal(t, []Escalation{
+		{Namespace: "tenant-a", Kind: "User", Subject: Wildcard, Path: EscalationBind, Roles: []string{"Role/pod-reader"}, Bindings: []string{"RoleBinding/tenant-a/binder"}},
+	}, snapshot.Escalations(config))
+}
@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	v1r "k8s.io/api/rbac/v1"
+)
+
+// StringMatch matches a value exactly, by prefix, by glob pattern or by (unanchored) regular expression.
+// Exactly one of its fields must be set.
+type StringMatch struct {
+	Exact  string `yaml:"exact,omitempty"`
+	Prefix string `yaml:"prefix,omitempty"`
+	Glob   string `yaml:"glob,omitempty"`
+	Regex  string `yaml:"regex,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// Exclusion drops every binding subject matched by all of its conditions, unset conditions match everything.
+// SubjectName is the name as written in the binding, Namespace is empty for ClusterRoleBindings.
+// Exclusions are evaluated in order and the first match decides, an Include exclusion keeps the subject.
+type Exclusion struct {
+	Name        string       `yaml:"name"`
+	Include     bool         `yaml:"include,omitempty"`
+	SubjectKind *StringMatch `yaml:"subjectKind,omitempty"`
+	SubjectName *StringMatch `yaml:"subjectName,omitempty"`
+	Namespace   *StringMatch `yaml:"namespace,omitempty"`
+	BindingName *StringMatch `yaml:"bindingName,omitempty"`
+}
+
+var systemSubjects = regexp.MustCompile("system")
+
+// DefaultExclusions are the exclusions the collector has always applied.
+// With serviceAccounts enabled, ServiceAccounts and the service account groups are kept instead.
+func DefaultExclusions(serviceAccounts bool) []Exclusion {
+	exclusions := []Exclusion{
+		{Name: "service-accounts", SubjectKind: &StringMatch{Exact: v1r.ServiceAccountKind}},
+	}
+	if serviceAccounts {
+		exclusions = []Exclusion{
+			{Name: "service-accounts", Include: true, SubjectKind: &StringMatch{Exact: v1r.ServiceAccountKind}},
+			{Name: "service-account-groups", Include: true, SubjectKind: &StringMatch{Exact: v1r.GroupKind}, SubjectName: &StringMatch{Prefix: serviceAccountGroup}},
+		}
+	}
+	return append(exclusions,
+		Exclusion{Name: "openshift-console-user-settings", Namespace: &StringMatch{Exact: "openshift-console-user-settings"}},
+		// matches "system" anywhere in the name like a glob cannot, * does not match the / of e.g. OIDC issuer prefixes
+		Exclusion{Name: "system-subjects", SubjectName: &StringMatch{Regex: "system", regex: systemSubjects}},
+	)
+}
+
+// compile checks that exactly one field is set and compiles the regular expression.
+func (m *StringMatch) compile() error {
+	set := 0
+	for _, value := range []string{m.Exact, m.Prefix, m.Glob, m.Regex} {
+		if value != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of exact, prefix, glob and regex must be set")
+	}
+	if m.Glob != "" {
+		if _, err := path.Match(m.Glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", m.Glob, err)
+		}
+	}
+	if m.Regex != "" {
+		regex, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex %q: %w", m.Regex, err)
+		}
+		m.regex = regex
+	}
+	return nil
+}
+
+// Matches reports whether value matches. A nil StringMatch matches every value.
+func (m *StringMatch) Matches(value string) bool {
+	switch {
+	case m == nil:
+		return true
+	case m.Exact != "":
+		return value == m.Exact
+	case m.Prefix != "":
+		return strings.HasPrefix(value, m.Prefix)
+	case m.Glob != "":
+		matched, _ := path.Match(m.Glob, value)
+		return matched
+	case m.regex != nil:
+		return m.regex.MatchString(value)
+	case m.Regex != "":
+		matched, _ := regexp.MatchString(m.Regex, value)
+		return matched
+	default:
+		return false
+	}
+}
+
+func (e *Exclusion) compile() error {
+	conditions := []struct {
+		field string
+		match *StringMatch
+	}{
+		{"subjectKind", e.SubjectKind},
+		{"subjectName", e.SubjectName},
+		{"namespace", e.Namespace},
+		{"bindingName", e.BindingName},
+	}
+	set := 0
+	for _, condition := range conditions {
+		if condition.match == nil {
+			continue
+		}
+		set++
+		if err := condition.match.compile(); err != nil {
+			return fmt.Errorf("exclusion %q: %s: %w", e.Name, condition.field, err)
+		}
+	}
+	if set == 0 {
+		return fmt.Errorf("exclusion %q has no conditions", e.Name)
+	}
+	return nil
+}
+
+// Matches reports whether the subject of a binding with the given name in the given namespace is excluded.
+func (e *Exclusion) Matches(subject v1r.Subject, namespace, bindingName string) bool {
+	return e.SubjectKind.Matches(subject.Kind) &&
+		e.SubjectName.Matches(subject.Name) &&
+		e.Namespace.Matches(namespace) &&
+		e.BindingName.Matches(bindingName)
+}
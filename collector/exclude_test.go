@@ -0,0 +1,100 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		match    *StringMatch
+		value    string
+		expected bool
+	}{
+		{name: "nil", match: nil, value: "anything", expected: true},
+		{name: "exact", match: &StringMatch{Exact: "kube-system"}, value: "kube-system", expected: true},
+		{name: "exact mismatch", match: &StringMatch{Exact: "kube-system"}, value: "kube-system2", expected: false},
+		{name: "prefix", match: &StringMatch{Prefix: "system:"}, value: "system:admin", expected: true},
+		{name: "prefix mismatch", match: &StringMatch{Prefix: "system:"}, value: "systemsteam@corp", expected: false},
+		{name: "glob", match: &StringMatch{Glob: "openshift-*"}, value: "openshift-monitoring", expected: true},
+		{name: "glob mismatch", match: &StringMatch{Glob: "openshift-*"}, value: "tenant-openshift", expected: false},
+		{name: "regex", match: &StringMatch{Regex: "^system:(node|kube)"}, value: "system:kube-scheduler", expected: true},
+		{name: "regex mismatch", match: &StringMatch{Regex: "^system:(node|kube)"}, value: "system:admin", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.match != nil {
+				assert.NoError(t, tt.match.compile())
+			}
+			assert.Equal(t, tt.expected, tt.match.Matches(tt.value))
+		})
+	}
+}
+
+func TestConfigValidateExclusions(t *testing.T) {
+	config := DefaultConfig()
+	assert.NoError(t, config.Validate())
+
+	config.Exclusions = []Exclusion{{Name: "empty"}}
+	assert.Error(t, config.Validate())
+
+	config.Exclusions = []Exclusion{{Name: "ambiguous", SubjectName: &StringMatch{Exact: "a", Prefix: "b"}}}
+	assert.Error(t, config.Validate())
+
+	config.Exclusions = []Exclusion{{Name: "broken", SubjectName: &StringMatch{Regex: "("}}}
+	assert.Error(t, config.Validate())
+}
+
+func TestCollectExclusions(t *testing.T) {
+	roles := RoleRules{RoleKey("tenant", "viewer"): granted}
+	roleBindings := v1r.RoleBindingList{
+		Items: []v1r.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "Role", Name: "viewer"},
+				Subjects: []v1r.Subject{
+					{Kind: "User", Name: "systemsteam@corp"},
+					{Kind: "User", Name: "system:admin"},
+					{Kind: "ServiceAccount", Name: "builder"},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "generated-viewers", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "Role", Name: "viewer"},
+				Subjects: []v1r.Subject{
+					{Kind: "User", Name: "alice"},
+				},
+			},
+		},
+	}
+
+	config := DefaultConfig()
+	config.Exclusions = []Exclusion{
+		{Name: "service-accounts", SubjectKind: &StringMatch{Exact: "ServiceAccount"}},
+		{Name: "system-users", SubjectName: &StringMatch{Prefix: "system:"}},
+		{Name: "generated", BindingName: &StringMatch{Regex: "^generated-"}, Namespace: &StringMatch{Glob: "ten*"}},
+	}
+	assert.NoError(t, config.Validate())
+
+	permissions := Collect(roles, RoleRules{}, &roleBindings, &v1r.ClusterRoleBindingList{}, nil, config)
+	assert.Equal(t, map[string]map[string]bool{"systemsteam@corp": {"tenant": true}}, permissions.Flat())
+
+	permissions = Collect(roles, RoleRules{}, &roleBindings, &v1r.ClusterRoleBindingList{}, nil, DefaultConfig())
+	assert.Equal(t, map[string]map[string]bool{"alice": {"tenant": true}}, permissions.Flat())
+}
+
+func TestDefaultExclusions(t *testing.T) {
+	exclusions := DefaultExclusions(false)
+	for _, name := range []string{"system:admin", "systemsteam@corp", "oidc:https://idp.corp/systemsteam", "ops-system"} {
+		exclusion := matchExclusion(exclusions, v1r.Subject{Kind: "User", Name: name}, "tenant", "viewers")
+		if assert.NotNil(t, exclusion, name) {
+			assert.Equal(t, "system-subjects", exclusion.Name)
+		}
+	}
+	assert.Nil(t, matchExclusion(exclusions, v1r.Subject{Kind: "User", Name: "oidc:https://idp.corp/alice"}, "tenant", "viewers"))
+}
@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"time"
+
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// now returns the time bindings are checked for expiry at, tests replace it.
+var now = time.Now
+
+// bindingExpiry parses the RFC 3339 timestamp of the expiry annotation. ok is false if the binding has no
+// expiry, err is set if the annotation is not a valid timestamp.
+func bindingExpiry(meta metav1.ObjectMeta, annotation string) (expiry time.Time, ok bool, err error) {
+	if annotation == "" {
+		return time.Time{}, false, nil
+	}
+	value, found := meta.Annotations[annotation]
+	if !found {
+		return time.Time{}, false, nil
+	}
+	expiry, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return expiry, true, nil
+}
+
+// NextBindingExpiry returns the earliest expiry after at of all bindings annotated with the expiry annotation,
+// false if no binding expires after at.
+func NextBindingExpiry(roleBindings *v1r.RoleBindingList, clusterRoleBindings *v1r.ClusterRoleBindingList, annotation string, at time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	check := func(meta metav1.ObjectMeta) {
+		expiry, ok, _ := bindingExpiry(meta, annotation)
+		if ok && expiry.After(at) && (!found || expiry.Before(next)) {
+			next, found = expiry, true
+		}
+	}
+	if annotation == "" {
+		return next, false
+	}
+	for _, rb := range roleBindings.Items {
+		check(rb.ObjectMeta)
+	}
+	for _, crb := range clusterRoleBindings.Items {
+		check(crb.ObjectMeta)
+	}
+	return next, found
+}
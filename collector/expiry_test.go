@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const expiryAnnotation = "multena.io/expires"
+
+func expiringRoleBinding(name, expires string) v1r.RoleBinding {
+	rb := v1r.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: name},
+		RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+		Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}},
+	}
+	if expires != "" {
+		rb.Annotations = map[string]string{expiryAnnotation: expires}
+	}
+	return rb
+}
+
+func TestCollectExpiredBindings(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+	defer func() { now = time.Now }()
+
+	roleBindings := v1r.RoleBindingList{Items: []v1r.RoleBinding{
+		expiringRoleBinding("permanent", ""),
+		expiringRoleBinding("expired", "2024-01-01T11:00:00Z"),
+		expiringRoleBinding("expiring-now", "2024-01-01T12:00:00Z"),
+		expiringRoleBinding("valid", "2024-01-01T13:00:00+01:00"),
+		expiringRoleBinding("later", "2024-01-02T00:00:00Z"),
+		expiringRoleBinding("invalid", "tomorrow"),
+	}}
+	clusterRoleBindings := v1r.ClusterRoleBindingList{Items: []v1r.ClusterRoleBinding{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "expired", Annotations: map[string]string{expiryAnnotation: "2023-12-31T00:00:00Z"}},
+			RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+			Subjects:   []v1r.Subject{{Kind: "User", Name: "bob"}},
+		},
+	}}
+
+	config := DefaultConfig()
+	config.ExpiryAnnotation = expiryAnnotation
+	permissions := Collect(RoleRules{}, RoleRules{"view": granted}, &roleBindings, &clusterRoleBindings, nil, config)
+	assert.Equal(t, map[string]map[string]bool{
+		"alice": {"permanent": true, "later": true, "invalid": true},
+	}, permissions.Flat())
+
+	next, ok := NextBindingExpiry(&roleBindings, &clusterRoleBindings, expiryAnnotation, at)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), next.UTC())
+
+	// without annotation all bindings are kept
+	permissions = Collect(RoleRules{}, RoleRules{"view": granted}, &roleBindings, &clusterRoleBindings, nil, DefaultConfig())
+	assert.Len(t, permissions.Flat()["alice"], 6)
+	_, ok = NextBindingExpiry(&roleBindings, &clusterRoleBindings, "", at)
+	assert.False(t, ok)
+}
@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"slices"
+	"sort"
+
+	v1r "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// OpenShiftGroupResource identifies the OpenShift Group objects read for group expansion.
+var OpenShiftGroupResource = schema.GroupVersionResource{Group: "user.openshift.io", Version: "v1", Resource: "groups"}
+
+// GroupMembers maps group names to the names of their member users.
+type GroupMembers map[string][]string
+
+// OpenShiftGroups reads the members of user.openshift.io/v1 Group objects.
+func OpenShiftGroups(list *unstructured.UnstructuredList) GroupMembers {
+	members := make(GroupMembers)
+	if list == nil {
+		return members
+	}
+	for _, group := range list.Items {
+		users, _, err := unstructured.NestedStringSlice(group.Object, "users")
+		if err != nil {
+			continue
+		}
+		members[group.GetName()] = users
+	}
+	return members
+}
+
+// Merge returns the union of the members of both, m is modified.
+func (m GroupMembers) Merge(other GroupMembers) GroupMembers {
+	if m == nil {
+		m = make(GroupMembers, len(other))
+	}
+	for group, users := range other {
+		for _, user := range users {
+			if !slices.Contains(m[group], user) {
+				m[group] = append(m[group], user)
+			}
+		}
+		if _, ok := m[group]; !ok {
+			m[group] = []string{}
+		}
+	}
+	return m
+}
+
+// ExpandGroups grants every member of a group the namespaces of the group, in addition to the namespaces
+// granted directly. The group entries are kept. Grants inherited from a group are recorded with Grant.Group set.
+func (p Permissions) ExpandGroups(members GroupMembers) {
+	groups := make([]string, 0, len(p.Groups))
+	for group := range p.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		for _, user := range members[group] {
+			for namespace := range p.Groups[group] {
+				p.add(v1r.UserKind, user, namespace)
+				for _, grant := range p.Provenance.Groups[group][namespace] {
+					grant.Group = group
+					p.Provenance.add(v1r.UserKind, user, namespace, grant)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func openShiftGroup(name string, users ...any) unstructured.Unstructured {
+	return unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "user.openshift.io/v1",
+		"kind":       "Group",
+		"metadata":   map[string]any{"name": name},
+		"users":      users,
+	}}
+}
+
+func TestOpenShiftGroups(t *testing.T) {
+	list := &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+		openShiftGroup("devs", "alice", "bob"),
+		openShiftGroup("empty"),
+	}}
+	assert.Equal(t, GroupMembers{"devs": {"alice", "bob"}, "empty": {}}, OpenShiftGroups(list))
+	assert.Empty(t, OpenShiftGroups(nil))
+}
+
+func TestSnapshotCollectExpandGroups(t *testing.T) {
+	snapshot := Snapshot{
+		Roles:        &v1r.RoleList{},
+		ClusterRoles: &v1r.ClusterRoleList{Items: []v1r.ClusterRole{{ObjectMeta: metav1.ObjectMeta{Name: "view"}, Rules: granted}}},
+		RoleBindings: &v1r.RoleBindingList{Items: []v1r.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "devs", Namespace: "tenant-a"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "Group", Name: "devs"}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: "tenant-b"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}},
+			},
+		}},
+		ClusterRoleBindings: &v1r.ClusterRoleBindingList{},
+		Groups:              GroupMembers{"devs": {"alice", "bob"}, "ops": {"carol"}},
+	}
+
+	config := DefaultConfig()
+	config.Format = FormatSubjects
+	config.ExpandGroups = true
+	permissions := snapshot.Collect(config)[DefaultProfile]
+
+	assert.Equal(t, map[string]map[string]bool{
+		"alice": {"tenant-a": true, "tenant-b": true},
+		"bob":   {"tenant-a": true},
+	}, permissions.Users)
+	assert.Equal(t, map[string]map[string]bool{"devs": {"tenant-a": true}}, permissions.Groups)
+
+	grants := permissions.Provenance.Explain("bob", true, false)["tenant-a"]
+	if assert.Len(t, grants, 1) {
+		assert.Equal(t, "devs", grants[0].Group)
+		assert.Equal(t, "devs", grants[0].BindingName)
+	}
+
+	config.ExpandGroups = false
+	assert.NotContains(t, snapshot.Collect(config)[DefaultProfile].Users, "bob")
+}
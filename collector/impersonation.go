@@ -0,0 +1,233 @@
+package collector
+
+import (
+	"strings"
+
+	v1r "k8s.io/api/rbac/v1"
+)
+
+// impersonationMatcher preselects the rules which may grant impersonation of core subjects.
+var impersonationMatcher = Matcher{Permissions: []Permission{{APIGroup: "", Resource: v1r.ResourceAll, Verb: "impersonate"}}}
+
+// impersonationTarget is a subject that may be impersonated. ServiceAccount targets are kept as users named by
+// serviceAccountUsername.
+type impersonationTarget struct {
+	Kind string
+	// Name is the subject name, or the prefix of the names covered by the target if Prefix is set.
+	Name   string
+	Prefix bool
+	// AnyNamespace covers the ServiceAccounts named Name in every namespace.
+	AnyNamespace bool
+}
+
+func (t impersonationTarget) String() string {
+	switch {
+	case t.AnyNamespace:
+		return t.Kind + "/" + serviceAccountUserPrefix + Wildcard + ":" + t.Name
+	case t.Prefix:
+		return t.Kind + "/" + t.Name + Wildcard
+	}
+	return t.Kind + "/" + t.Name
+}
+
+// matches reports whether the target covers the subject name.
+func (t impersonationTarget) matches(subject string) bool {
+	switch {
+	case t.AnyNamespace:
+		rest, ok := strings.CutPrefix(subject, serviceAccountUserPrefix)
+		namespace, name, found := strings.Cut(rest, ":")
+		return ok && found && namespace != "" && name == t.Name
+	case t.Prefix:
+		return strings.HasPrefix(subject, t.Name)
+	}
+	return subject == t.Name
+}
+
+// impersonator is a subject with the targets it may impersonate and the grants allowing it.
+type impersonator struct {
+	kind, name string
+	targets    map[impersonationTarget]Grant
+}
+
+// impersonationTargets returns the subjects a rule allows to impersonate. Bindings in a namespace only allow
+// impersonating the ServiceAccounts of that namespace, users and groups are cluster scoped. Like the RBAC
+// authorizer, resourceNames match exactly and a rule without resourceNames covers every subject.
+func impersonationTargets(rule v1r.PolicyRule, namespace string) []impersonationTarget {
+	var targets []impersonationTarget
+	add := func(target impersonationTarget) {
+		if len(rule.ResourceNames) == 0 {
+			target.Prefix = true
+			targets = append(targets, target)
+			return
+		}
+		for _, name := range rule.ResourceNames {
+			target := target
+			target.Name += name
+			targets = append(targets, target)
+		}
+	}
+	for _, resource := range rule.Resources {
+		if (resource == "users" || resource == v1r.ResourceAll) && namespace == "" {
+			add(impersonationTarget{Kind: v1r.UserKind})
+		}
+		if (resource == "groups" || resource == v1r.ResourceAll) && namespace == "" {
+			add(impersonationTarget{Kind: v1r.GroupKind})
+		}
+		if resource == "serviceaccounts" || resource == v1r.ResourceAll {
+			switch {
+			case namespace != "":
+				add(impersonationTarget{Kind: v1r.UserKind, Name: serviceAccountUserPrefix + namespace + ":"})
+			case len(rule.ResourceNames) == 0:
+				add(impersonationTarget{Kind: v1r.UserKind, Name: serviceAccountUserPrefix})
+			default:
+				add(impersonationTarget{Kind: v1r.UserKind, AnyNamespace: true})
+			}
+		}
+	}
+	return targets
+}
+
+// findImpersonators collects the subjects of all bindings referencing impersonation rules. Subjects are named
+// as in Collect and excluded subjects are skipped. With group resolution, members of impersonating groups are
+// impersonators themselves.
+func (s Snapshot) findImpersonators(clusterRoles v1r.ClusterRoleList, config Config) []*impersonator {
+	roles, clusterRoleRules := evaluateRoles(*s.Roles, clusterRoles, impersonationMatcher)
+	exclusions := config.exclusions()
+	found := make(map[impersonationTarget]*impersonator)
+	var order []impersonationTarget
+
+	add := func(subjects []v1r.Subject, grant Grant, bindingNamespace string, rules []v1r.PolicyRule) {
+		for _, subject := range subjects {
+			kind, name, ok := config.subjectName(exclusions, subject, bindingNamespace, grant.BindingName)
+			if !ok {
+				continue
+			}
+			names := []impersonationTarget{{Kind: kind, Name: name}}
+			if kind == v1r.GroupKind && config.ResolvesGroups() {
+				for _, member := range s.Groups[name] {
+					names = append(names, impersonationTarget{Kind: v1r.UserKind, Name: member})
+				}
+			}
+			for _, key := range names {
+				i, ok := found[key]
+				if !ok {
+					i = &impersonator{kind: key.Kind, name: key.Name, targets: make(map[impersonationTarget]Grant)}
+					found[key] = i
+					order = append(order, key)
+				}
+				for _, rule := range rules {
+					for _, target := range impersonationTargets(rule, bindingNamespace) {
+						if _, ok := i.targets[target]; !ok {
+							i.targets[target] = grant
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for _, rb := range s.RoleBindings.Items {
+		if rules := refRules(rb.RoleRef, rb.Namespace, roles, clusterRoleRules); len(rules) > 0 {
+			add(rb.Subjects, newGrant("RoleBinding", rb.Name, rb.Namespace, rb.RoleRef, rules), rb.Namespace, rules)
+		}
+	}
+	for _, crb := range s.ClusterRoleBindings.Items {
+		if rules := refRules(crb.RoleRef, "", roles, clusterRoleRules); len(rules) > 0 {
+			add(crb.Subjects, newGrant("ClusterRoleBinding", crb.Name, "", crb.RoleRef, rules), "", rules)
+		}
+	}
+
+	impersonators := make([]*impersonator, 0, len(order))
+	for _, key := range order {
+		impersonators = append(impersonators, found[key])
+	}
+	return impersonators
+}
+
+// canImpersonateUser reports whether the impersonator may impersonate any user, which the API server
+// requires for impersonating groups.
+func (i *impersonator) canImpersonateUser() bool {
+	for target := range i.targets {
+		if target.Kind == v1r.UserKind {
+			return true
+		}
+	}
+	return false
+}
+
+// addImpersonation adds the namespaces the impersonators may access by impersonating other subjects as indirect
+// entries, until no more are found. Impersonating every group allows impersonating system:masters and grants
+// ClusterWide access.
+func (p Permissions) addImpersonation(impersonators []*impersonator) {
+	for changed := true; changed; {
+		changed = false
+		for _, i := range impersonators {
+			userAllowed := i.canImpersonateUser()
+			for target, grant := range i.targets {
+				if target.Kind == v1r.GroupKind && !userAllowed {
+					continue
+				}
+				grant.Impersonates = target.String()
+				for namespace := range p.targetNamespaces(target) {
+					if p.addIndirect(i.kind, i.name, namespace) {
+						changed = true
+					}
+					if p.isIndirect(i.kind, i.name, namespace) {
+						p.Provenance.add(i.kind, i.name, namespace, grant)
+					}
+				}
+			}
+		}
+	}
+}
+
+// targetNamespaces returns the namespaces the target may access directly or indirectly.
+func (p Permissions) targetNamespaces(target impersonationTarget) map[string]bool {
+	namespaces := make(map[string]bool)
+	direct, indirect := p.Users, p.IndirectUsers
+	if target.Kind == v1r.GroupKind {
+		direct, indirect = p.Groups, p.IndirectGroups
+		if target.Prefix && target.Name == "" {
+			namespaces[ClusterWide] = true
+		}
+	}
+	for _, subjects := range []map[string]map[string]bool{direct, indirect} {
+		for subject, granted := range subjects {
+			if !target.matches(subject) {
+				continue
+			}
+			for namespace := range granted {
+				namespaces[namespace] = true
+			}
+		}
+	}
+	return namespaces
+}
+
+// addIndirect records an indirect entry unless the subject has direct access, it reports whether it was new.
+func (p Permissions) addIndirect(kind, subject, namespace string) bool {
+	direct, indirect := p.Users, p.IndirectUsers
+	if kind == v1r.GroupKind {
+		direct, indirect = p.Groups, p.IndirectGroups
+	}
+	if direct[subject][namespace] || indirect[subject][namespace] {
+		return false
+	}
+	if _, ok := indirect[subject]; !ok {
+		indirect[subject] = make(map[string]bool)
+	}
+	indirect[subject][namespace] = true
+	return true
+}
+
+func (p Permissions) isIndirect(kind, subject, namespace string) bool {
+	if kind == v1r.GroupKind {
+		return p.IndirectGroups[subject][namespace]
+	}
+	return p.IndirectUsers[subject][namespace]
+}
+
+// Indirect returns the entries subjects may only access by impersonation as permissions of their own.
+func (p Permissions) Indirect() Permissions {
+	return Permissions{Users: p.IndirectUsers, Groups: p.IndirectGroups}
+}
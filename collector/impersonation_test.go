@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func impersonationRule(resource string, names ...string) v1r.PolicyRule {
+	return v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{resource}, Verbs: []string{"impersonate"}, ResourceNames: names}
+}
+
+func clusterRoleBinding(name, role string, subjects ...v1r.Subject) v1r.ClusterRoleBinding {
+	return v1r.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: role},
+		Subjects:   subjects,
+	}
+}
+
+func roleBinding(namespace, name, role string, subjects ...v1r.Subject) v1r.RoleBinding {
+	return v1r.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: role},
+		Subjects:   subjects,
+	}
+}
+
+func TestImpersonationTargets(t *testing.T) {
+	assert.Equal(t, []impersonationTarget{{Kind: "User", Name: "bob"}}, impersonationTargets(impersonationRule("users", "bob"), ""))
+	assert.Equal(t, []impersonationTarget{{Kind: "Group", Prefix: true}}, impersonationTargets(impersonationRule("groups"), ""))
+	assert.Empty(t, impersonationTargets(impersonationRule("users"), "tenant"))
+	assert.Equal(t, []impersonationTarget{{Kind: "User", Name: "system:serviceaccount:ci:deployer"}},
+		impersonationTargets(impersonationRule("serviceaccounts", "deployer"), "ci"))
+	assert.Equal(t, []impersonationTarget{{Kind: "User", Name: "system:serviceaccount:ci:", Prefix: true}},
+		impersonationTargets(impersonationRule("serviceaccounts"), "ci"))
+	assert.Equal(t, []impersonationTarget{{Kind: "User", Name: "system:serviceaccount:", Prefix: true}},
+		impersonationTargets(impersonationRule("serviceaccounts"), ""))
+
+	anyNamespace := impersonationTargets(impersonationRule("serviceaccounts", "deployer"), "")
+	assert.Equal(t, []impersonationTarget{{Kind: "User", Name: "deployer", AnyNamespace: true}}, anyNamespace)
+	assert.Equal(t, "User/system:serviceaccount:*:deployer", anyNamespace[0].String())
+	assert.True(t, anyNamespace[0].matches("system:serviceaccount:ci:deployer"))
+	assert.False(t, anyNamespace[0].matches("system:serviceaccount:ci:builder"))
+	assert.False(t, anyNamespace[0].matches("system:serviceaccount:ci:deployer-2"))
+	assert.False(t, anyNamespace[0].matches("deployer"))
+
+	// resourceNames are no patterns, a trailing * is part of the name
+	literal := impersonationTargets(impersonationRule("users", "adm*"), "")
+	assert.Equal(t, []impersonationTarget{{Kind: "User", Name: "adm*"}}, literal)
+	assert.True(t, literal[0].matches("adm*"))
+	assert.False(t, literal[0].matches("admin1"))
+	all := impersonationTargets(impersonationRule("users"), "")
+	assert.Equal(t, "User/*", all[0].String())
+	assert.True(t, all[0].matches("admin1"))
+}
+
+func TestSnapshotCollectImpersonation(t *testing.T) {
+	user := func(name string) v1r.Subject { return v1r.Subject{Kind: "User", Name: name} }
+	view := []v1r.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}}
+
+	snapshot := Snapshot{
+		Roles: &v1r.RoleList{},
+		ClusterRoles: &v1r.ClusterRoleList{Items: []v1r.ClusterRole{
+			{ObjectMeta: metav1.ObjectMeta{Name: "view"}, Rules: view},
+			{ObjectMeta: metav1.ObjectMeta{Name: "impersonate-bob"}, Rules: []v1r.PolicyRule{impersonationRule("users", "bob")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "impersonate-carol"}, Rules: []v1r.PolicyRule{impersonationRule("users", "carol")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "impersonate-devs"}, Rules: []v1r.PolicyRule{impersonationRule("groups", "devs")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "impersonate-nobody"}, Rules: []v1r.PolicyRule{impersonationRule("users", "nobody")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "impersonate-serviceaccounts"}, Rules: []v1r.PolicyRule{impersonationRule("serviceaccounts")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "impersonate-deployer"}, Rules: []v1r.PolicyRule{impersonationRule("serviceaccounts", "deployer")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "impersonate-b-star"}, Rules: []v1r.PolicyRule{impersonationRule("users", "b*")}},
+		}},
+		RoleBindings: &v1r.RoleBindingList{Items: []v1r.RoleBinding{
+			roleBinding("tenant-a", "view", "view", user("alice")),
+			roleBinding("tenant-b", "view", "view", user("bob"), user("alice")),
+			roleBinding("tenant-c", "view", "view", user("carol")),
+			roleBinding("tenant-d", "view", "view", v1r.Subject{Kind: "Group", Name: "devs"}),
+			roleBinding("ci", "view", "view", v1r.Subject{Kind: "ServiceAccount", Name: "deployer"}),
+			roleBinding("ci", "impersonate", "impersonate-serviceaccounts", user("frank")),
+		}},
+		ClusterRoleBindings: &v1r.ClusterRoleBindingList{Items: []v1r.ClusterRoleBinding{
+			clusterRoleBinding("alice-bob", "impersonate-bob", user("alice")),
+			clusterRoleBinding("bob-carol", "impersonate-carol", user("bob")),
+			clusterRoleBinding("dave-devs", "impersonate-devs", user("dave"), user("erin")),
+			clusterRoleBinding("erin-nobody", "impersonate-nobody", user("erin")),
+			clusterRoleBinding("grace-deployer", "impersonate-deployer", user("grace")),
+			// only a user literally named b* could be impersonated
+			clusterRoleBinding("heidi-b-star", "impersonate-b-star", user("heidi")),
+		}},
+	}
+
+	config := DefaultConfig()
+	config.Matcher = Matcher{Permissions: []Permission{{APIGroup: "", Resource: "pods", Verb: "get"}}}
+	config.ServiceAccounts = true
+	config.Impersonation = true
+	permissions := snapshot.Collect(config)[DefaultProfile]
+
+	assert.Equal(t, map[string]map[string]bool{
+		"alice":                             {"tenant-a": true, "tenant-b": true},
+		"bob":                               {"tenant-b": true},
+		"carol":                             {"tenant-c": true},
+		"system:serviceaccount:ci:deployer": {"ci": true},
+	}, permissions.Users)
+	assert.Equal(t, map[string]map[string]bool{
+		"alice": {"tenant-c": true},
+		"bob":   {"tenant-c": true},
+		"erin":  {"tenant-d": true},
+		"frank": {"ci": true},
+		"grace": {"ci": true},
+	}, permissions.IndirectUsers)
+	assert.Equal(t, permissions.IndirectUsers, permissions.Indirect().Flat())
+
+	grants := permissions.Provenance.Explain("alice", true, false)
+	if assert.Len(t, grants["tenant-c"], 1) {
+		assert.Equal(t, "User/bob", grants["tenant-c"][0].Impersonates)
+		assert.Equal(t, "alice-bob", grants["tenant-c"][0].BindingName)
+	}
+	assert.Len(t, grants["tenant-b"], 1, "direct access is not explained by impersonation")
+
+	snapshot.Overrides = Overrides{Deny: []Override{{Name: "no-c", Kind: "User", Subject: "alice", Namespaces: []string{"tenant-c"}}}}
+	permissions = snapshot.Collect(config)[DefaultProfile]
+	assert.NotContains(t, permissions.IndirectUsers, "alice")
+
+	config.Impersonation = false
+	assert.Empty(t, snapshot.Collect(config)[DefaultProfile].IndirectUsers)
+}
+
+func TestSnapshotCollectImpersonationBuiltinGroups(t *testing.T) {
+	view := []v1r.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}}
+	snapshot := Snapshot{
+		Roles: &v1r.RoleList{},
+		ClusterRoles: &v1r.ClusterRoleList{Items: []v1r.ClusterRole{
+			{ObjectMeta: metav1.ObjectMeta{Name: "view"}, Rules: view},
+			{ObjectMeta: metav1.ObjectMeta{Name: "impersonate-bob"}, Rules: []v1r.PolicyRule{impersonationRule("users", "bob")}},
+		}},
+		RoleBindings: &v1r.RoleBindingList{Items: []v1r.RoleBinding{
+			roleBinding("tenant-b", "view", "view", v1r.Subject{Kind: "User", Name: "bob"}),
+		}},
+		ClusterRoleBindings: &v1r.ClusterRoleBindingList{Items: []v1r.ClusterRoleBinding{
+			clusterRoleBinding("authenticated-bob", "impersonate-bob", v1r.Subject{Kind: "Group", Name: "system:authenticated"}),
+		}},
+	}
+	config := DefaultConfig()
+	config.Matcher = Matcher{Permissions: []Permission{{APIGroup: "", Resource: "pods", Verb: "get"}}}
+	config.Impersonation = true
+	permissions := snapshot.Collect(config)[DefaultProfile]
+	assert.Empty(t, permissions.IndirectUsers, "built-in groups are excluded by default")
+	assert.Empty(t, permissions.IndirectGroups)
+
+	config.BuiltinGroups.Authenticated = BuiltinGroupKeep
+	permissions = snapshot.Collect(config)[DefaultProfile]
+	assert.Equal(t, map[string]map[string]bool{"system:authenticated": {"tenant-b": true}}, permissions.IndirectGroups)
+
+	config.BuiltinGroups.Authenticated = BuiltinGroupWildcard
+	permissions = snapshot.Collect(config)[DefaultProfile]
+	assert.Equal(t, map[string]map[string]bool{Wildcard: {"tenant-b": true}}, permissions.IndirectUsers)
+}
@@ -0,0 +1,236 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// DefaultLDAPFilter finds a groupOfNames by its common name, {group} is replaced by the escaped group name.
+	DefaultLDAPFilter = "(&(objectClass=groupOfNames)(cn={group}))"
+	// DefaultLDAPMemberAttribute holds the DNs of the members of a group entry.
+	DefaultLDAPMemberAttribute = "member"
+	// DefaultLDAPUserAttribute holds the username of a user entry.
+	DefaultLDAPUserAttribute = "uid"
+	// DefaultLDAPCacheTTL is how long the members of a group are cached.
+	DefaultLDAPCacheTTL = 5 * time.Minute
+	// DefaultLDAPTimeout limits connecting to the server and every request.
+	DefaultLDAPTimeout = 10 * time.Second
+)
+
+// LDAPConfig configures the resolution of Group subjects to member usernames by LDAP searches.
+// A group is searched below BaseDN with Filter, the DNs in its MemberAttribute are read and
+// their UserAttribute is the username. With Nested, members which are groups themselves are resolved recursively.
+type LDAPConfig struct {
+	URL    string `yaml:"url"`
+	BindDN string `yaml:"bindDN"`
+	// BindPasswordFile is read for the bind password, e.g. from a mounted Secret.
+	BindPasswordFile string        `yaml:"bindPasswordFile"`
+	BaseDN           string        `yaml:"baseDN"`
+	Filter           string        `yaml:"filter"`
+	MemberAttribute  string        `yaml:"memberAttribute"`
+	UserAttribute    string        `yaml:"userAttribute"`
+	Nested           bool          `yaml:"nested"`
+	CacheTTL         time.Duration `yaml:"cacheTTL"`
+	// Timeout limits connecting and every request, so an unresponsive server cannot stall recomputes.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Enabled reports whether an LDAP server is configured.
+func (c LDAPConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+func (c *LDAPConfig) compile() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.BaseDN == "" {
+		return fmt.Errorf("ldap: baseDN must be set")
+	}
+	if c.Filter == "" {
+		c.Filter = DefaultLDAPFilter
+	}
+	if !strings.Contains(c.Filter, "{group}") {
+		return fmt.Errorf("ldap: filter %q does not contain {group}", c.Filter)
+	}
+	if _, err := ldap.CompileFilter(strings.ReplaceAll(c.Filter, "{group}", "group")); err != nil {
+		return fmt.Errorf("ldap: invalid filter %q: %w", c.Filter, err)
+	}
+	if c.MemberAttribute == "" {
+		c.MemberAttribute = DefaultLDAPMemberAttribute
+	}
+	if c.UserAttribute == "" {
+		c.UserAttribute = DefaultLDAPUserAttribute
+	}
+	if c.CacheTTL == 0 {
+		c.CacheTTL = DefaultLDAPCacheTTL
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("ldap: timeout must not be negative")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultLDAPTimeout
+	}
+	return nil
+}
+
+// LDAPResolver resolves groups to their members, caching the members of every group for the configured TTL.
+// It is safe for concurrent use.
+type LDAPResolver struct {
+	config   LDAPConfig
+	password string
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedMembers
+}
+
+type cachedMembers struct {
+	users   []string
+	expires time.Time
+}
+
+// NewLDAPResolver creates a resolver for a validated configuration.
+func NewLDAPResolver(config LDAPConfig) (*LDAPResolver, error) {
+	r := &LDAPResolver{config: config, now: time.Now, cache: make(map[string]cachedMembers)}
+	if config.BindPasswordFile != "" {
+		password, err := os.ReadFile(config.BindPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: error reading bind password: %w", err)
+		}
+		r.password = strings.TrimSpace(string(password))
+	}
+	return r, nil
+}
+
+// Resolve returns the members of the groups. Groups not found in LDAP have no members, groups that could not
+// be searched are reported in the error and keep their cached members, if any.
+func (r *LDAPResolver) Resolve(groups []string) (GroupMembers, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	members := make(GroupMembers, len(groups))
+	var expired []string
+	for _, group := range groups {
+		cached, ok := r.cache[group]
+		if ok {
+			members[group] = cached.users
+		}
+		if !ok || !now.Before(cached.expires) {
+			expired = append(expired, group)
+		}
+	}
+	if len(expired) == 0 {
+		return members, nil
+	}
+
+	conn, err := r.connect()
+	if err != nil {
+		return members, err
+	}
+	defer conn.Close()
+
+	var errs []error
+	for _, group := range expired {
+		users, err := r.search(conn, group)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ldap: error resolving group %q: %w", group, err))
+			continue
+		}
+		r.cache[group] = cachedMembers{users: users, expires: now.Add(r.config.CacheTTL)}
+		members[group] = users
+	}
+	return members, errors.Join(errs...)
+}
+
+func (r *LDAPResolver) connect() (ldap.Client, error) {
+	conn, err := ldap.DialURL(r.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: r.config.Timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: error connecting to %s: %w", r.config.URL, err)
+	}
+	conn.SetTimeout(r.config.Timeout)
+	if r.config.BindDN != "" {
+		err = conn.Bind(r.config.BindDN, r.password)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ldap: error binding as %q: %w", r.config.BindDN, err)
+	}
+	return conn, nil
+}
+
+// search looks up the group entry and collects the usernames of its members.
+func (r *LDAPResolver) search(conn ldap.Client, group string) ([]string, error) {
+	filter := strings.ReplaceAll(r.config.Filter, "{group}", ldap.EscapeFilter(group))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		r.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, []string{r.config.MemberAttribute}, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]bool)
+	visited := make(map[string]bool)
+	for _, entry := range result.Entries {
+		visited[entry.DN] = true
+		err = r.collectMembers(conn, entry.GetAttributeValues(r.config.MemberAttribute), users, visited)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sorted := make([]string, 0, len(users))
+	for user := range users {
+		sorted = append(sorted, user)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// collectMembers reads the member entries, adding the usernames of users and, with nested groups,
+// the members of groups. visited guards against membership cycles.
+func (r *LDAPResolver) collectMembers(conn ldap.Client, dns []string, users, visited map[string]bool) error {
+	for _, dn := range dns {
+		if visited[dn] {
+			continue
+		}
+		visited[dn] = true
+		result, err := conn.Search(ldap.NewSearchRequest(
+			dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+			"(objectClass=*)", []string{r.config.UserAttribute, r.config.MemberAttribute}, nil,
+		))
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range result.Entries {
+			if nested := entry.GetAttributeValues(r.config.MemberAttribute); len(nested) > 0 {
+				if r.config.Nested {
+					if err := r.collectMembers(conn, nested, users, visited); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if user := entry.GetAttributeValue(r.config.UserAttribute); user != "" {
+				users[user] = true
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package collector
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+// ldapStandIn is an in-process LDAP server answering binds and searches from a fixed directory.
+// Filters support and, or, not, equality and presence.
+type ldapStandIn struct {
+	listener net.Listener
+	entries  []*ldap.Entry
+	password string
+	searches atomic.Int32
+}
+
+func newLDAPStandIn(t *testing.T, password string, entries ...*ldap.Entry) *ldapStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapStandIn{listener: listener, entries: entries, password: password}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *ldapStandIn) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := int64(ldap.LDAPResultSuccess)
+			if op.Children[1].Value.(string) != "" && string(op.Children[2].Data.Bytes()) != s.password {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			s.respond(conn, id, ldapResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			s.searches.Add(1)
+			s.search(conn, id, op)
+		default:
+			return
+		}
+	}
+}
+
+func (s *ldapStandIn) search(conn net.Conn, id int64, op *ber.Packet) {
+	baseDN := op.Children[0].Value.(string)
+	scope := op.Children[1].Value.(int64)
+	filter := op.Children[6]
+	found := false
+	for _, entry := range s.entries {
+		if scope == ldap.ScopeBaseObject && !strings.EqualFold(entry.DN, baseDN) ||
+			scope != ldap.ScopeBaseObject && !strings.HasSuffix(strings.ToLower(entry.DN), strings.ToLower(baseDN)) {
+			continue
+		}
+		found = true
+		if !matchesFilter(entry, filter) {
+			continue
+		}
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, ""))
+		attributes := ber.NewSequence("")
+		for _, attribute := range entry.Attributes {
+			a := ber.NewSequence("")
+			a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute.Name, ""))
+			values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, value := range attribute.Values {
+				values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+			}
+			a.AppendChild(values)
+			attributes.AppendChild(a)
+		}
+		result.AppendChild(attributes)
+		s.respond(conn, id, result)
+	}
+	code := int64(ldap.LDAPResultSuccess)
+	if !found {
+		code = ldap.LDAPResultNoSuchObject
+	}
+	s.respond(conn, id, ldapResult(ldap.ApplicationSearchResultDone, code))
+}
+
+func (s *ldapStandIn) respond(conn net.Conn, id int64, op *ber.Packet) {
+	envelope := ber.NewSequence("")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	envelope.AppendChild(op)
+	_, _ = conn.Write(envelope.Bytes())
+}
+
+func ldapResult(tag ber.Tag, code int64) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return result
+}
+
+func matchesFilter(entry *ldap.Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchesFilter(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchesFilter(entry, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matchesFilter(entry, filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		name := filter.Children[0].Value.(string)
+		value := filter.Children[1].Value.(string)
+		for _, v := range entry.GetEqualFoldAttributeValues(name) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		name := filter.Data.String()
+		return strings.EqualFold(name, "objectClass") || len(entry.GetEqualFoldAttributeValues(name)) > 0
+	default:
+		return false
+	}
+}
+
+func ldapEntries() []*ldap.Entry {
+	return []*ldap.Entry{
+		ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{"objectClass": {"person"}, "uid": {"alice"}}),
+		ldap.NewEntry("uid=bob,ou=people,dc=example,dc=com", map[string][]string{"objectClass": {"person"}, "uid": {"bob"}}),
+		ldap.NewEntry("uid=carol,ou=people,dc=example,dc=com", map[string][]string{"objectClass": {"person"}, "uid": {"carol"}}),
+		ldap.NewEntry("cn=devs,ou=groups,dc=example,dc=com", map[string][]string{
+			"objectClass": {"groupOfNames"},
+			"cn":          {"devs"},
+			"member":      {"uid=alice,ou=people,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com", "uid=gone,ou=people,dc=example,dc=com"},
+		}),
+		ldap.NewEntry("cn=admins,ou=groups,dc=example,dc=com", map[string][]string{
+			"objectClass": {"groupOfNames"},
+			"cn":          {"admins"},
+			"member":      {"uid=bob,ou=people,dc=example,dc=com", "cn=devs,ou=groups,dc=example,dc=com"},
+		}),
+	}
+}
+
+func TestLDAPResolver(t *testing.T) {
+	server := newLDAPStandIn(t, "secret", ldapEntries()...)
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0o600))
+
+	tests := []struct {
+		name     string
+		nested   bool
+		expected GroupMembers
+	}{
+		{
+			name:     "direct members",
+			expected: GroupMembers{"devs": {"alice"}, "admins": {"bob"}, "missing": {}},
+		},
+		{
+			name:     "nested members",
+			nested:   true,
+			expected: GroupMembers{"devs": {"alice", "bob"}, "admins": {"alice", "bob"}, "missing": {}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{LDAP: LDAPConfig{
+				URL:              server.url(),
+				BindDN:           "cn=collector,dc=example,dc=com",
+				BindPasswordFile: passwordFile,
+				BaseDN:           "ou=groups,dc=example,dc=com",
+				Nested:           tt.nested,
+			}}
+			assert.NoError(t, config.Validate())
+			assert.True(t, config.ResolvesGroups())
+			resolver, err := NewLDAPResolver(config.LDAP)
+			assert.NoError(t, err)
+
+			members, err := resolver.Resolve([]string{"devs", "admins", "missing"})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, members)
+		})
+	}
+}
+
+func TestLDAPResolverCache(t *testing.T) {
+	server := newLDAPStandIn(t, "", ldapEntries()...)
+	config := LDAPConfig{URL: server.url(), BaseDN: "dc=example,dc=com", CacheTTL: time.Minute}
+	assert.NoError(t, config.compile())
+	resolver, err := NewLDAPResolver(config)
+	assert.NoError(t, err)
+	now := time.Now()
+	resolver.now = func() time.Time { return now }
+
+	members, err := resolver.Resolve([]string{"devs"})
+	assert.NoError(t, err)
+	assert.Equal(t, GroupMembers{"devs": {"alice"}}, members)
+	searches := server.searches.Load()
+
+	members, err = resolver.Resolve([]string{"devs"})
+	assert.NoError(t, err)
+	assert.Equal(t, GroupMembers{"devs": {"alice"}}, members)
+	assert.Equal(t, searches, server.searches.Load())
+
+	now = now.Add(time.Minute)
+	_, err = resolver.Resolve([]string{"devs"})
+	assert.NoError(t, err)
+	assert.Greater(t, server.searches.Load(), searches)
+
+	// cached members are kept if the server is unreachable
+	_ = server.listener.Close()
+	now = now.Add(time.Minute)
+	members, err = resolver.Resolve([]string{"devs"})
+	assert.Error(t, err)
+	assert.Equal(t, GroupMembers{"devs": {"alice"}}, members)
+}
+
+func TestLDAPResolverInvalidCredentials(t *testing.T) {
+	server := newLDAPStandIn(t, "secret", ldapEntries()...)
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("wrong"), 0o600))
+	config := LDAPConfig{URL: server.url(), BindDN: "cn=collector", BindPasswordFile: passwordFile, BaseDN: "dc=example,dc=com"}
+	assert.NoError(t, config.compile())
+	resolver, err := NewLDAPResolver(config)
+	assert.NoError(t, err)
+	_, err = resolver.Resolve([]string{"devs"})
+	assert.Error(t, err)
+}
+
+func TestLDAPResolverTimeout(t *testing.T) {
+	// a server accepting connections without ever answering
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+		}
+	}()
+
+	config := LDAPConfig{URL: "ldap://" + listener.Addr().String(), BaseDN: "dc=example,dc=com", Timeout: 50 * time.Millisecond}
+	assert.NoError(t, config.compile())
+	resolver, err := NewLDAPResolver(config)
+	assert.NoError(t, err)
+	start := time.Now()
+	_, err = resolver.Resolve([]string{"devs"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestLDAPConfigInvalid(t *testing.T) {
+	for _, config := range []LDAPConfig{
+		{URL: "ldap://localhost"},
+		{URL: "ldap://localhost", BaseDN: "dc=example", Filter: "(cn=devs)"},
+		{URL: "ldap://localhost", BaseDN: "dc=example", Filter: "(cn={group}"},
+		{URL: "ldap://localhost", BaseDN: "dc=example", Timeout: -time.Second},
+	} {
+		assert.Error(t, config.compile())
+	}
+}
+
+func TestGroupMembersMerge(t *testing.T) {
+	members := GroupMembers{"devs": {"alice"}}
+	members = members.Merge(GroupMembers{"devs": {"alice", "bob"}, "ops": {}})
+	assert.Equal(t, GroupMembers{"devs": {"alice", "bob"}, "ops": {}}, members)
+	assert.Equal(t, GroupMembers{"ops": {"carol"}}, GroupMembers(nil).Merge(GroupMembers{"ops": {"carol"}}))
+}
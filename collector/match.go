@@ -0,0 +1,102 @@
+package collector
+
+import (
+	"slices"
+	"strings"
+
+	v1r "k8s.io/api/rbac/v1"
+)
+
+// Permission is an (apiGroup, resource, verb) tuple that grants access to a namespace's telemetry.
+// Resource may name a subresource, e.g. "pods/log". A "*" in any field matches every value.
+type Permission struct {
+	APIGroup string `yaml:"apiGroup"`
+	Resource string `yaml:"resource"`
+	Verb     string `yaml:"verb"`
+}
+
+// Matcher decides whether a set of policy rules grants at least one of its permissions.
+type Matcher struct {
+	Permissions []Permission `yaml:"permissions"`
+}
+
+// DefaultMatcher matches any rule containing one of the verbs the collector has always considered
+// (or the "*" verb), regardless of API groups and resources.
+func DefaultMatcher() Matcher {
+	verbs := []string{"approve", "create", "edit", "escalate", "get", "impersonate", "list", "patch", "update", "use", "view", "watch"}
+	permissions := make([]Permission, 0, len(verbs))
+	for _, verb := range verbs {
+		permissions = append(permissions, Permission{APIGroup: v1r.APIGroupAll, Resource: v1r.ResourceAll, Verb: verb})
+	}
+	return Matcher{Permissions: permissions}
+}
+
+// Matches reports whether any of the rules grants any of the matcher's permissions.
+func (m Matcher) Matches(rules []v1r.PolicyRule) bool {
+	for _, rule := range rules {
+		if m.matchesRule(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchingRules returns the rules granting any of the matcher's permissions.
+func (m Matcher) MatchingRules(rules []v1r.PolicyRule) []v1r.PolicyRule {
+	var matching []v1r.PolicyRule
+	for _, rule := range rules {
+		if m.matchesRule(rule) {
+			matching = append(matching, rule)
+		}
+	}
+	return matching
+}
+
+func (m Matcher) matchesRule(rule v1r.PolicyRule) bool {
+	for _, permission := range m.Permissions {
+		if permission.allowedBy(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedBy follows the wildcard semantics of the Kubernetes RBAC authorizer.
+// Rules limited to resourceNames never grant access to a whole collection,
+// so they only satisfy permissions with a wildcard resource.
+func (p Permission) allowedBy(rule v1r.PolicyRule) bool {
+	return p.verbAllowedBy(rule) && p.apiGroupAllowedBy(rule) && p.resourceAllowedBy(rule)
+}
+
+func (p Permission) verbAllowedBy(rule v1r.PolicyRule) bool {
+	if p.Verb == v1r.VerbAll {
+		return len(rule.Verbs) > 0
+	}
+	return slices.Contains(rule.Verbs, v1r.VerbAll) || slices.Contains(rule.Verbs, p.Verb)
+}
+
+func (p Permission) apiGroupAllowedBy(rule v1r.PolicyRule) bool {
+	if p.APIGroup == v1r.APIGroupAll {
+		return true
+	}
+	return slices.Contains(rule.APIGroups, v1r.APIGroupAll) || slices.Contains(rule.APIGroups, p.APIGroup)
+}
+
+func (p Permission) resourceAllowedBy(rule v1r.PolicyRule) bool {
+	if p.Resource == v1r.ResourceAll {
+		return true
+	}
+	if len(rule.ResourceNames) > 0 {
+		return false
+	}
+	_, subresource, _ := strings.Cut(p.Resource, "/")
+	for _, resource := range rule.Resources {
+		if resource == v1r.ResourceAll || resource == p.Resource {
+			return true
+		}
+		if subresource != "" && resource == "*/"+subresource {
+			return true
+		}
+	}
+	return false
+}
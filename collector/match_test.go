@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+)
+
+func TestMatcherMatches(t *testing.T) {
+	telemetry := Matcher{
+		Permissions: []Permission{
+			{APIGroup: "", Resource: "pods", Verb: "get"},
+			{APIGroup: "", Resource: "pods/log", Verb: "get"},
+			{APIGroup: "monitoring.coreos.com", Resource: "*", Verb: "get"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		matcher  Matcher
+		rule     v1r.PolicyRule
+		expected bool
+	}{
+		{
+			name:     "exact match",
+			matcher:  telemetry,
+			rule:     v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}},
+			expected: true,
+		},
+		{
+			name:     "other resource",
+			matcher:  telemetry,
+			rule:     v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}},
+			expected: false,
+		},
+		{
+			name:     "other api group",
+			matcher:  telemetry,
+			rule:     v1r.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+			expected: false,
+		},
+		{
+			name:     "other verb",
+			matcher:  telemetry,
+			rule:     v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"delete"}},
+			expected: false,
+		},
+		{
+			name:     "wildcard rule",
+			matcher:  telemetry,
+			rule:     v1r.PolicyRule{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+			expected: true,
+		},
+		{
+			name:     "wildcard subresource rule",
+			matcher:  telemetry,
+			rule:     v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"*/log"}, Verbs: []string{"get"}},
+			expected: true,
+		},
+		{
+			name:     "wildcard resource permission",
+			matcher:  telemetry,
+			rule:     v1r.PolicyRule{APIGroups: []string{"monitoring.coreos.com"}, Resources: []string{"prometheusrules"}, Verbs: []string{"get"}},
+			expected: true,
+		},
+		{
+			name:     "resource names restricted rule",
+			matcher:  telemetry,
+			rule:     v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, ResourceNames: []string{"pod-a"}, Verbs: []string{"get"}},
+			expected: false,
+		},
+		{
+			name:     "default matcher ignores resources",
+			matcher:  DefaultMatcher(),
+			rule:     v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"cm-a"}, Verbs: []string{"get"}},
+			expected: true,
+		},
+		{
+			name:     "default matcher with wildcard verb",
+			matcher:  DefaultMatcher(),
+			rule:     v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"*"}},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.matcher.Matches([]v1r.PolicyRule{tt.rule}))
+		})
+	}
+}
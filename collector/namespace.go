@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"fmt"
+	"path"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NamespaceSelection restricts the output to namespaces matching the label selector, one of the include patterns
+// (if any) and none of the exclude patterns. Patterns are globs on the namespace name.
+type NamespaceSelection struct {
+	Selector string   `yaml:"selector"`
+	Include  []string `yaml:"include"`
+	Exclude  []string `yaml:"exclude"`
+
+	selector labels.Selector
+}
+
+// Enabled reports whether any namespace restriction is configured.
+func (s NamespaceSelection) Enabled() bool {
+	return s.Selector != "" || len(s.Include) > 0 || len(s.Exclude) > 0
+}
+
+func (s *NamespaceSelection) compile() error {
+	selector, err := labels.Parse(s.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid namespace selector %q: %w", s.Selector, err)
+	}
+	s.selector = selector
+	for _, pattern := range append(append([]string{}, s.Include...), s.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Selects reports whether the namespace is part of the output.
+func (s NamespaceSelection) Selects(namespace v1.Namespace) bool {
+	selector := s.selector
+	if selector == nil {
+		var err error
+		if selector, err = labels.Parse(s.Selector); err != nil {
+			return false
+		}
+	}
+	if !selector.Matches(labels.Set(namespace.Labels)) {
+		return false
+	}
+	if len(s.Include) > 0 && !matchesAny(s.Include, namespace.Name) {
+		return false
+	}
+	return !matchesAny(s.Exclude, namespace.Name)
+}
+
+// SelectNamespaces returns the names of all selected namespaces.
+func (s NamespaceSelection) SelectNamespaces(namespaces *v1.NamespaceList) map[string]bool {
+	if namespaces == nil {
+		return map[string]bool{}
+	}
+	selected := make(map[string]bool, len(namespaces.Items))
+	for _, namespace := range namespaces.Items {
+		if s.Selects(namespace) {
+			selected[namespace.Name] = true
+		}
+	}
+	return selected
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func namespace(name string, labels map[string]string) v1.Namespace {
+	return v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestNamespaceSelection(t *testing.T) {
+	namespaces := &v1.NamespaceList{
+		Items: []v1.Namespace{
+			namespace("tenant-a", map[string]string{"multena.io/tenant": "true"}),
+			namespace("tenant-b", map[string]string{"multena.io/tenant": "false"}),
+			namespace("kube-system", map[string]string{"multena.io/tenant": "true"}),
+			namespace("shared", nil),
+		},
+	}
+
+	tests := []struct {
+		name      string
+		selection NamespaceSelection
+		expected  map[string]bool
+	}{
+		{
+			name:      "empty selection",
+			selection: NamespaceSelection{},
+			expected:  map[string]bool{"tenant-a": true, "tenant-b": true, "kube-system": true, "shared": true},
+		},
+		{
+			name:      "label selector",
+			selection: NamespaceSelection{Selector: "multena.io/tenant=true"},
+			expected:  map[string]bool{"tenant-a": true, "kube-system": true},
+		},
+		{
+			name:      "label selector and exclude",
+			selection: NamespaceSelection{Selector: "multena.io/tenant=true", Exclude: []string{"kube-*", "openshift-*"}},
+			expected:  map[string]bool{"tenant-a": true},
+		},
+		{
+			name:      "include",
+			selection: NamespaceSelection{Include: []string{"tenant-*"}},
+			expected:  map[string]bool{"tenant-a": true, "tenant-b": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, tt.selection.compile())
+			assert.Equal(t, tt.expected, tt.selection.SelectNamespaces(namespaces))
+		})
+	}
+}
+
+func TestNamespaceSelectionInvalid(t *testing.T) {
+	config := DefaultConfig()
+	config.Namespaces = NamespaceSelection{Selector: "a in (b"}
+	assert.Error(t, config.Validate())
+
+	config.Namespaces = NamespaceSelection{Exclude: []string{"["}}
+	assert.Error(t, config.Validate())
+}
+
+func TestCollectNamespaceSelection(t *testing.T) {
+	roleBindings := v1r.RoleBindingList{
+		Items: []v1r.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers", Namespace: "tenant-a"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers", Namespace: "kube-public"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers", Namespace: "deleted"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}},
+			},
+		},
+	}
+	clusterRoleBindings := v1r.ClusterRoleBindingList{
+		Items: []v1r.ClusterRoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "bob"}},
+			},
+		},
+	}
+	namespaces := &v1.NamespaceList{
+		Items: []v1.Namespace{
+			namespace("tenant-a", nil),
+			namespace("kube-public", nil),
+		},
+	}
+
+	config := DefaultConfig()
+	config.Namespaces = NamespaceSelection{Exclude: []string{"kube-*"}}
+	assert.NoError(t, config.Validate())
+	assert.True(t, config.NeedsNamespaces())
+
+	permissions := Collect(RoleRules{}, RoleRules{"view": granted}, &roleBindings, &clusterRoleBindings, namespaces, config)
+	assert.Equal(t, map[string]map[string]bool{
+		"alice": {"tenant-a": true},
+		"bob":   {"#cluster-wide": true},
+	}, permissions.Flat())
+}
+
+func TestCollectExpandClusterWide(t *testing.T) {
+	roleBindings := v1r.RoleBindingList{}
+	clusterRoleBindings := v1r.ClusterRoleBindingList{
+		Items: []v1r.ClusterRoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "bob"}},
+			},
+		},
+	}
+	namespaces := &v1.NamespaceList{
+		Items: []v1.Namespace{
+			namespace("tenant-a", map[string]string{"multena.io/tenant": "true"}),
+			namespace("tenant-b", map[string]string{"multena.io/tenant": "true"}),
+			namespace("kube-public", nil),
+		},
+	}
+
+	config := DefaultConfig()
+	config.ExpandClusterWide = true
+	assert.NoError(t, config.Validate())
+	assert.True(t, config.NeedsNamespaces())
+
+	permissions := Collect(RoleRules{}, RoleRules{"view": granted}, &roleBindings, &clusterRoleBindings, namespaces, config)
+	assert.Equal(t, map[string]map[string]bool{
+		"bob": {"tenant-a": true, "tenant-b": true, "kube-public": true},
+	}, permissions.Flat())
+
+	config.Namespaces = NamespaceSelection{Selector: "multena.io/tenant=true"}
+	assert.NoError(t, config.Validate())
+	permissions = Collect(RoleRules{}, RoleRules{"view": granted}, &roleBindings, &clusterRoleBindings, namespaces, config)
+	assert.Equal(t, map[string]map[string]bool{
+		"bob": {"tenant-a": true, "tenant-b": true},
+	}, permissions.Flat())
+}
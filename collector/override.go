@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	v1r "k8s.io/api/rbac/v1"
+)
+
+const (
+	// OverrideKind is the binding kind of grants recorded for overrides.
+	OverrideKind = "Override"
+	// OverrideAllow marks grants added by an allow entry.
+	OverrideAllow = "allow"
+	// OverrideDeny marks namespaces removed by a deny entry.
+	OverrideDeny = "deny"
+)
+
+// Overrides are static exceptions merged into the collected permissions. Deny entries take precedence over
+// allow entries and over grants by bindings.
+type Overrides struct {
+	Allow []Override `yaml:"allow"`
+	Deny  []Override `yaml:"deny"`
+}
+
+// Override grants or denies a subject access to namespaces. Namespaces are the values written to the output,
+// i.e. tenants if a tenant mapping is configured, and may be ClusterWide. Deny entries accept globs.
+type Override struct {
+	// Name identifies the entry in the provenance.
+	Name       string   `yaml:"name"`
+	Kind       string   `yaml:"kind"`
+	Subject    string   `yaml:"subject"`
+	Namespaces []string `yaml:"namespaces"`
+}
+
+// OverridesSource locates the overrides document, either a file or a key of a ConfigMap.
+type OverridesSource struct {
+	File      string             `yaml:"file"`
+	ConfigMap ConfigMapReference `yaml:"configMap"`
+}
+
+// ConfigMapReference references a key of a ConfigMap.
+type ConfigMapReference struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	// Key defaults to DefaultOverridesKey.
+	Key string `yaml:"key"`
+}
+
+// DefaultOverridesKey is the ConfigMap key of the overrides document.
+const DefaultOverridesKey = "overrides.yaml"
+
+// Enabled reports whether an overrides document is configured.
+func (s OverridesSource) Enabled() bool {
+	return s.File != "" || s.ConfigMap.Name != ""
+}
+
+func (s *OverridesSource) compile() error {
+	if s.File != "" && s.ConfigMap.Name != "" {
+		return fmt.Errorf("overrides: only one of file and configMap may be set")
+	}
+	if s.ConfigMap.Name != "" && s.ConfigMap.Namespace == "" {
+		return fmt.Errorf("overrides: configMap namespace must be set")
+	}
+	if s.ConfigMap.Name != "" && s.ConfigMap.Key == "" {
+		s.ConfigMap.Key = DefaultOverridesKey
+	}
+	return nil
+}
+
+// ParseOverrides reads and validates an overrides document.
+func ParseOverrides(data []byte) (Overrides, error) {
+	var overrides Overrides
+	if err := yaml.Unmarshal(data, &overrides); err != nil {
+		return Overrides{}, fmt.Errorf("overrides: %w", err)
+	}
+	for _, entries := range [][]Override{overrides.Allow, overrides.Deny} {
+		for _, override := range entries {
+			if err := override.validate(); err != nil {
+				return Overrides{}, err
+			}
+		}
+	}
+	// allow entries are written as is, a pattern would end up as a namespace in the output
+	for _, override := range overrides.Allow {
+		for _, namespace := range override.Namespaces {
+			if strings.ContainsAny(namespace, `*?[\`) {
+				return Overrides{}, fmt.Errorf("overrides: allow entry %q has namespace pattern %q, only deny entries accept globs", override.Name, namespace)
+			}
+		}
+	}
+	return overrides, nil
+}
+
+func (o Override) validate() error {
+	if o.Name == "" {
+		return fmt.Errorf("overrides: entry for %q has no name", o.Subject)
+	}
+	if o.Kind != v1r.UserKind && o.Kind != v1r.GroupKind {
+		return fmt.Errorf("overrides: entry %q has invalid kind %q, expected User or Group", o.Name, o.Kind)
+	}
+	if o.Subject == "" || len(o.Namespaces) == 0 {
+		return fmt.Errorf("overrides: entry %q needs a subject and namespaces", o.Name)
+	}
+	for _, namespace := range o.Namespaces {
+		if _, err := path.Match(namespace, ""); err != nil {
+			return fmt.Errorf("overrides: entry %q has invalid namespace pattern %q: %w", o.Name, namespace, err)
+		}
+	}
+	return nil
+}
+
+func (o Override) grant(override string) Grant {
+	return Grant{BindingKind: OverrideKind, BindingName: o.Name, Override: override}
+}
+
+// Allow adds the namespaces of the allow entries.
+func (p Permissions) Allow(overrides Overrides) {
+	for _, override := range overrides.Allow {
+		for _, namespace := range override.Namespaces {
+			p.add(override.Kind, override.Subject, namespace)
+			p.Provenance.add(override.Kind, override.Subject, namespace, override.grant(OverrideAllow))
+		}
+	}
+}
+
+// Deny removes the direct and indirect namespaces matching a deny entry. The provenance of a removed namespace is kept and
+// records the deny entry, so explain shows the bindings which would have granted access.
+func (p Permissions) Deny(overrides Overrides) {
+	for _, override := range overrides.Deny {
+		direct, indirect := p.Users, p.IndirectUsers
+		if override.Kind == v1r.GroupKind {
+			direct, indirect = p.Groups, p.IndirectGroups
+		}
+		for _, subjects := range []map[string]map[string]bool{direct, indirect} {
+			for namespace := range subjects[override.Subject] {
+				if !override.matches(namespace) {
+					continue
+				}
+				delete(subjects[override.Subject], namespace)
+				p.Provenance.add(override.Kind, override.Subject, namespace, override.grant(OverrideDeny))
+			}
+			if namespaces, ok := subjects[override.Subject]; ok && len(namespaces) == 0 {
+				delete(subjects, override.Subject)
+			}
+		}
+	}
+}
+
+func (o Override) matches(namespace string) bool {
+	for _, pattern := range o.Namespaces {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const overridesDocument = `
+allow:
+  - name: auditors
+    kind: Group
+    subject: auditors
+    namespaces: ["#cluster-wide"]
+  - name: contractor-sandbox
+    kind: User
+    subject: contractor
+    namespaces: [sandbox, regulated-payments]
+deny:
+  - name: regulated
+    kind: User
+    subject: contractor
+    namespaces: ["regulated-*"]
+`
+
+func TestParseOverrides(t *testing.T) {
+	overrides, err := ParseOverrides([]byte(overridesDocument))
+	assert.NoError(t, err)
+	assert.Len(t, overrides.Allow, 2)
+	assert.Equal(t, Override{Name: "regulated", Kind: "User", Subject: "contractor", Namespaces: []string{"regulated-*"}}, overrides.Deny[0])
+
+	for _, document := range []string{
+		"allow: [{kind: User, subject: alice, namespaces: [a]}]",
+		"allow: [{name: x, kind: ServiceAccount, subject: alice, namespaces: [a]}]",
+		"deny: [{name: x, kind: User, subject: alice}]",
+		"deny: [{name: x, kind: User, subject: alice, namespaces: ['[']}]",
+		"allow: [{name: x, kind: User, subject: alice, namespaces: [team-*]}]",
+		"allow: {}",
+	} {
+		_, err := ParseOverrides([]byte(document))
+		assert.Error(t, err, document)
+	}
+}
+
+func TestSnapshotCollectOverrides(t *testing.T) {
+	overrides, err := ParseOverrides([]byte(overridesDocument))
+	assert.NoError(t, err)
+	snapshot := Snapshot{
+		Roles:        &v1r.RoleList{},
+		ClusterRoles: &v1r.ClusterRoleList{Items: []v1r.ClusterRole{{ObjectMeta: metav1.ObjectMeta{Name: "view"}, Rules: granted}}},
+		RoleBindings: &v1r.RoleBindingList{Items: []v1r.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "contractors", Namespace: "regulated-accounts"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "contractor"}, {Kind: "Group", Name: "contractors"}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "contractors", Namespace: "public"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "contractor"}},
+			},
+		}},
+		ClusterRoleBindings: &v1r.ClusterRoleBindingList{},
+		Groups:              GroupMembers{"contractors": {"contractor"}, "auditors": {"eve"}},
+		Overrides:           overrides,
+	}
+
+	config := DefaultConfig()
+	config.Format = FormatSubjects
+	config.ExpandGroups = true
+	permissions := snapshot.Collect(config)[DefaultProfile]
+
+	assert.Equal(t, map[string]map[string]bool{
+		"contractor": {"public": true, "sandbox": true},
+		"eve":        {ClusterWide: true},
+	}, permissions.Users)
+	assert.Equal(t, map[string]map[string]bool{
+		"auditors":    {ClusterWide: true},
+		"contractors": {"regulated-accounts": true},
+	}, permissions.Groups)
+
+	explanation := permissions.Provenance.Explain("contractor", true, false)
+	assert.Contains(t, explanation["sandbox"], Grant{BindingKind: OverrideKind, BindingName: "contractor-sandbox", Override: OverrideAllow})
+	assert.Contains(t, explanation["regulated-payments"], Grant{BindingKind: OverrideKind, BindingName: "regulated", Override: OverrideDeny})
+	assert.Contains(t, explanation["regulated-accounts"], Grant{BindingKind: OverrideKind, BindingName: "regulated", Override: OverrideDeny})
+	assert.Len(t, explanation["regulated-accounts"], 3)
+}
+
+func TestOverridesSourceInvalid(t *testing.T) {
+	config := DefaultConfig()
+	config.Overrides = OverridesSource{File: "overrides.yaml", ConfigMap: ConfigMapReference{Name: "overrides", Namespace: "default"}}
+	assert.Error(t, config.Validate())
+
+	config.Overrides = OverridesSource{ConfigMap: ConfigMapReference{Name: "overrides"}}
+	assert.Error(t, config.Validate())
+
+	config.Overrides = OverridesSource{ConfigMap: ConfigMapReference{Name: "overrides", Namespace: "default"}}
+	assert.NoError(t, config.Validate())
+	assert.Equal(t, DefaultOverridesKey, config.Overrides.ConfigMap.Key)
+}
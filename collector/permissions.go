@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	v1r "k8s.io/api/rbac/v1"
+)
+
+// Format selects the schema of the generated permission document.
+type Format string
+
+const (
+	// FormatFlat maps every subject to its namespaces, users and groups share one map.
+	FormatFlat Format = "flat"
+	// FormatSubjects keeps users and groups in separate sections.
+	FormatSubjects Format = "subjects"
+)
+
+// Permissions holds the namespaces each subject may access, separated by subject kind.
+type Permissions struct {
+	Users  map[string]map[string]bool `yaml:"users"`
+	Groups map[string]map[string]bool `yaml:"groups"`
+	// IndirectUsers and IndirectGroups hold the namespaces subjects may only access by impersonating other subjects,
+	// they are not part of the permission document, see Indirect.
+	IndirectUsers  map[string]map[string]bool `yaml:"-"`
+	IndirectGroups map[string]map[string]bool `yaml:"-"`
+	// Provenance explains every entry of Users and Groups, it is not part of the permission document.
+	Provenance Provenance `yaml:"-"`
+}
+
+func NewPermissions() Permissions {
+	return Permissions{
+		Users:          make(map[string]map[string]bool, 1000),
+		Groups:         make(map[string]map[string]bool, 100),
+		IndirectUsers:  make(map[string]map[string]bool),
+		IndirectGroups: make(map[string]map[string]bool),
+		Provenance:     newProvenance(),
+	}
+}
+
+func (p Permissions) add(kind, subject, namespace string) {
+	subjects := p.Users
+	if kind == v1r.GroupKind {
+		subjects = p.Groups
+	}
+	if _, ok := subjects[subject]; !ok {
+		subjects[subject] = make(map[string]bool)
+	}
+	subjects[subject][namespace] = true
+}
+
+// remove deletes the namespace of the subject, and the subject once it has no namespaces left.
+func (p Permissions) remove(kind, subject, namespace string) {
+	subjects := p.Users
+	if kind == v1r.GroupKind {
+		subjects = p.Groups
+	}
+	delete(subjects[subject], namespace)
+	if len(subjects[subject]) == 0 {
+		delete(subjects, subject)
+	}
+}
+
+// clone returns a deep copy of the direct entries and their provenance, the indirect entries are left empty.
+func (p Permissions) clone() Permissions {
+	clone := NewPermissions()
+	for _, subjects := range []struct{ from, to map[string]map[string]bool }{{p.Users, clone.Users}, {p.Groups, clone.Groups}} {
+		for subject, namespaces := range subjects.from {
+			subjects.to[subject] = maps.Clone(namespaces)
+		}
+	}
+	for _, subjects := range []struct{ from, to map[string]map[string][]Grant }{{p.Provenance.Users, clone.Provenance.Users}, {p.Provenance.Groups, clone.Provenance.Groups}} {
+		for subject, namespaces := range subjects.from {
+			subjects.to[subject] = make(map[string][]Grant, len(namespaces))
+			for namespace, grants := range namespaces {
+				subjects.to[subject][namespace] = slices.Clone(grants)
+			}
+		}
+	}
+	return clone
+}
+
+// Flat merges users and groups into a single map, as the collector has always written it.
+func (p Permissions) Flat() map[string]map[string]bool {
+	flat := make(map[string]map[string]bool, len(p.Users)+len(p.Groups))
+	for _, subjects := range []map[string]map[string]bool{p.Users, p.Groups} {
+		for subject, namespaces := range subjects {
+			if _, ok := flat[subject]; !ok {
+				flat[subject] = make(map[string]bool, len(namespaces))
+			}
+			for namespace := range namespaces {
+				flat[subject][namespace] = true
+			}
+		}
+	}
+	return flat
+}
+
+// Document returns the permissions in the schema selected by format, ready to be marshalled.
+func (p Permissions) Document(format Format) (any, error) {
+	switch format {
+	case "", FormatFlat:
+		return p.Flat(), nil
+	case FormatSubjects:
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
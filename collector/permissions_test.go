@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCollectSeparatesUsersAndGroups(t *testing.T) {
+	roles := RoleRules{RoleKey("namespaceA", "viewer"): granted}
+	roleBindings := v1r.RoleBindingList{
+		Items: []v1r.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers", Namespace: "namespaceA"},
+				RoleRef:    v1r.RoleRef{Kind: "Role", Name: "viewer"},
+				Subjects: []v1r.Subject{
+					{Kind: "User", Name: "devs"},
+				},
+			},
+		},
+	}
+	clusterRoleBindings := v1r.ClusterRoleBindingList{
+		Items: []v1r.ClusterRoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects: []v1r.Subject{
+					{Kind: "Group", Name: "devs"},
+				},
+			},
+		},
+	}
+
+	permissions := Collect(roles, RoleRules{"view": granted}, &roleBindings, &clusterRoleBindings, nil, DefaultConfig())
+	assert.Equal(t, map[string]map[string]bool{"devs": {"namespaceA": true}}, permissions.Users)
+	assert.Equal(t, map[string]map[string]bool{"devs": {"#cluster-wide": true}}, permissions.Groups)
+	assert.Equal(t, map[string]map[string]bool{"devs": {"namespaceA": true, "#cluster-wide": true}}, permissions.Flat())
+}
+
+func TestPermissionsDocument(t *testing.T) {
+	permissions := NewPermissions()
+	permissions.add("User", "alice", "namespaceA")
+	permissions.add("Group", "devs", "namespaceB")
+
+	flat, err := permissions.Document(FormatFlat)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]bool{"alice": {"namespaceA": true}, "devs": {"namespaceB": true}}, flat)
+
+	subjects, err := permissions.Document(FormatSubjects)
+	assert.NoError(t, err)
+	assert.Equal(t, permissions, subjects)
+
+	_, err = permissions.Document("unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"cmp"
+	"slices"
+
+	v1r "k8s.io/api/rbac/v1"
+)
+
+// Grant explains why a subject may access a namespace: the binding, the role it references
+// and the rules of that role matched by the matcher.
+type Grant struct {
+	BindingKind      string `yaml:"bindingKind"`
+	BindingName      string `yaml:"bindingName"`
+	BindingNamespace string `yaml:"bindingNamespace,omitempty"`
+	RoleKind         string `yaml:"roleKind"`
+	RoleName         string `yaml:"roleName"`
+	Rules            []Rule `yaml:"rules,omitempty"`
+	// Group is set if the grant is inherited from a binding of this group.
+	Group string `yaml:"group,omitempty"`
+	// Override is OverrideAllow or OverrideDeny for grants of BindingKind OverrideKind.
+	Override string `yaml:"override,omitempty"`
+	// Impersonates is set for indirect grants, the binding allows impersonating this Kind/Name.
+	Impersonates string `yaml:"impersonates,omitempty"`
+}
+
+// Rule is a policy rule in the notation of the Kubernetes API.
+type Rule struct {
+	Verbs           []string `yaml:"verbs"`
+	APIGroups       []string `yaml:"apiGroups,omitempty"`
+	Resources       []string `yaml:"resources,omitempty"`
+	ResourceNames   []string `yaml:"resourceNames,omitempty"`
+	NonResourceURLs []string `yaml:"nonResourceURLs,omitempty"`
+}
+
+// Provenance holds the grants of every subject and namespace in Permissions.
+type Provenance struct {
+	Users  map[string]map[string][]Grant `yaml:"users"`
+	Groups map[string]map[string][]Grant `yaml:"groups"`
+}
+
+func newGrant(bindingKind, bindingName, bindingNamespace string, ref v1r.RoleRef, rules []v1r.PolicyRule) Grant {
+	grant := Grant{
+		BindingKind:      bindingKind,
+		BindingName:      bindingName,
+		BindingNamespace: bindingNamespace,
+		RoleKind:         ref.Kind,
+		RoleName:         ref.Name,
+		Rules:            make([]Rule, 0, len(rules)),
+	}
+	for _, rule := range rules {
+		grant.Rules = append(grant.Rules, Rule{
+			Verbs:           rule.Verbs,
+			APIGroups:       rule.APIGroups,
+			Resources:       rule.Resources,
+			ResourceNames:   rule.ResourceNames,
+			NonResourceURLs: rule.NonResourceURLs,
+		})
+	}
+	return grant
+}
+
+func newProvenance() Provenance {
+	return Provenance{
+		Users:  make(map[string]map[string][]Grant, 1000),
+		Groups: make(map[string]map[string][]Grant, 100),
+	}
+}
+
+// add records the grant, grants are kept sorted by binding and a binding is only recorded once per group.
+func (p Provenance) add(kind, subject, namespace string, grant Grant) {
+	subjects := p.Users
+	if kind == v1r.GroupKind {
+		subjects = p.Groups
+	}
+	if _, ok := subjects[subject]; !ok {
+		subjects[subject] = make(map[string][]Grant)
+	}
+	grants := subjects[subject][namespace]
+	i, found := slices.BinarySearchFunc(grants, grant, compareGrants)
+	if !found {
+		subjects[subject][namespace] = slices.Insert(grants, i, grant)
+	}
+}
+
+// remove deletes the grant, and the subject's namespace once it has no grants left.
+func (p Provenance) remove(kind, subject, namespace string, grant Grant) {
+	subjects := p.Users
+	if kind == v1r.GroupKind {
+		subjects = p.Groups
+	}
+	grants := subjects[subject][namespace]
+	i, found := slices.BinarySearchFunc(grants, grant, compareGrants)
+	if !found {
+		return
+	}
+	if grants = slices.Delete(grants, i, i+1); len(grants) > 0 {
+		subjects[subject][namespace] = grants
+		return
+	}
+	delete(subjects[subject], namespace)
+	if len(subjects[subject]) == 0 {
+		delete(subjects, subject)
+	}
+}
+
+func compareGrants(a, b Grant) int {
+	if c := cmp.Compare(a.BindingKind, b.BindingKind); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.BindingNamespace, b.BindingNamespace); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.BindingName, b.BindingName); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.Group, b.Group); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.Override, b.Override); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Impersonates, b.Impersonates)
+}
+
+// Explain returns the grants of a subject per namespace. Users and groups are only looked up if their
+// flag is set, grants of a user and a group with the same name are merged.
+func (p Provenance) Explain(subject string, users, groups bool) map[string][]Grant {
+	explanation := make(map[string][]Grant)
+	if users {
+		for namespace, grants := range p.Users[subject] {
+			explanation[namespace] = append(explanation[namespace], grants...)
+		}
+	}
+	if groups {
+		for namespace, grants := range p.Groups[subject] {
+			explanation[namespace] = append(explanation[namespace], grants...)
+		}
+	}
+	return explanation
+}
@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCollectProvenance(t *testing.T) {
+	getPods := v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}
+	deletePods := v1r.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"delete"}}
+	roles, clusterRoles := GetRoles(
+		v1r.RoleList{Items: []v1r.Role{
+			{ObjectMeta: metav1.ObjectMeta{Name: "viewer", Namespace: "tenant"}, Rules: []v1r.PolicyRule{getPods, deletePods}},
+		}},
+		v1r.ClusterRoleList{Items: []v1r.ClusterRole{
+			{ObjectMeta: metav1.ObjectMeta{Name: "view"}, Rules: []v1r.PolicyRule{getPods}},
+		}},
+		DefaultMatcher(),
+	)
+	roleBindings := v1r.RoleBindingList{
+		Items: []v1r.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "Role", Name: "viewer"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}, {Kind: "User", Name: "alice"}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster-viewers", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}},
+			},
+		},
+	}
+	clusterRoleBindings := v1r.ClusterRoleBindingList{
+		Items: []v1r.ClusterRoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "auditors"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "Group", Name: "auditors"}},
+			},
+		},
+	}
+
+	permissions := Collect(roles, clusterRoles, &roleBindings, &clusterRoleBindings, nil, DefaultConfig())
+
+	getPodsRule := Rule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}
+	assert.Equal(t, map[string][]Grant{
+		"tenant": {
+			{BindingKind: "RoleBinding", BindingName: "cluster-viewers", BindingNamespace: "tenant", RoleKind: "ClusterRole", RoleName: "view", Rules: []Rule{getPodsRule}},
+			{BindingKind: "RoleBinding", BindingName: "viewers", BindingNamespace: "tenant", RoleKind: "Role", RoleName: "viewer", Rules: []Rule{getPodsRule}},
+		},
+	}, permissions.Provenance.Explain("alice", true, true))
+	assert.Equal(t, map[string][]Grant{
+		ClusterWide: {
+			{BindingKind: "ClusterRoleBinding", BindingName: "auditors", RoleKind: "ClusterRole", RoleName: "view", Rules: []Rule{getPodsRule}},
+		},
+	}, permissions.Provenance.Explain("auditors", true, true))
+	assert.Empty(t, permissions.Provenance.Explain("auditors", true, false))
+}
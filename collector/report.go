@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"fmt"
+
+	v1r "k8s.io/api/rbac/v1"
+)
+
+// IssueKind classifies a problem found in a binding.
+type IssueKind string
+
+const (
+	// IssueDanglingRoleRef marks a binding referencing a role that does not exist. Creating the role later
+	// silently grants access to the binding's subjects.
+	IssueDanglingRoleRef IssueKind = "DanglingRoleRef"
+	// IssueRoleRefKindMismatch marks a binding referencing a role kind it may not reference, or referencing a
+	// missing role while a role of the other kind with that name exists.
+	IssueRoleRefKindMismatch IssueKind = "RoleRefKindMismatch"
+	// IssueNoSubjects marks a binding without subjects.
+	IssueNoSubjects IssueKind = "NoSubjects"
+)
+
+// IssueKinds lists all kinds of binding issues.
+var IssueKinds = []IssueKind{IssueDanglingRoleRef, IssueRoleRefKindMismatch, IssueNoSubjects}
+
+// BindingIssue is a problem found in a RoleBinding or ClusterRoleBinding.
+type BindingIssue struct {
+	Kind             IssueKind `yaml:"kind"`
+	BindingKind      string    `yaml:"bindingKind"`
+	BindingName      string    `yaml:"bindingName"`
+	BindingNamespace string    `yaml:"bindingNamespace,omitempty"`
+	RoleKind         string    `yaml:"roleKind"`
+	RoleName         string    `yaml:"roleName"`
+	Message          string    `yaml:"message"`
+}
+
+// CheckBindings reports bindings with dangling RoleRefs, RoleRef kind mismatches and empty subject lists.
+// roles and clusterRoles must contain every existing role, as returned by GetRoles.
+func CheckBindings(roles, clusterRoles RoleRules, roleBindings *v1r.RoleBindingList, clusterRoleBindings *v1r.ClusterRoleBindingList) []BindingIssue {
+	var issues []BindingIssue
+	for _, rb := range roleBindings.Items {
+		issues = append(issues, checkBinding("RoleBinding", rb.Name, rb.Namespace, rb.RoleRef, rb.Subjects, roles, clusterRoles)...)
+	}
+	for _, crb := range clusterRoleBindings.Items {
+		issues = append(issues, checkBinding("ClusterRoleBinding", crb.Name, "", crb.RoleRef, crb.Subjects, roles, clusterRoles)...)
+	}
+	return issues
+}
+
+func checkBinding(bindingKind, bindingName, bindingNamespace string, ref v1r.RoleRef, subjects []v1r.Subject, roles, clusterRoles RoleRules) []BindingIssue {
+	var issues []BindingIssue
+	issue := func(kind IssueKind, format string, args ...any) {
+		issues = append(issues, BindingIssue{
+			Kind:             kind,
+			BindingKind:      bindingKind,
+			BindingName:      bindingName,
+			BindingNamespace: bindingNamespace,
+			RoleKind:         ref.Kind,
+			RoleName:         ref.Name,
+			Message:          fmt.Sprintf(format, args...),
+		})
+	}
+
+	_, roleExists := roles[RoleKey(bindingNamespace, ref.Name)]
+	_, clusterRoleExists := clusterRoles[ref.Name]
+	switch {
+	case ref.Kind == "Role" && bindingNamespace == "":
+		issue(IssueRoleRefKindMismatch, "ClusterRoleBindings can only reference ClusterRoles")
+	case ref.Kind != "Role" && ref.Kind != "ClusterRole":
+		issue(IssueRoleRefKindMismatch, "unknown role kind %q", ref.Kind)
+	case ref.Kind == "Role" && !roleExists && clusterRoleExists:
+		issue(IssueRoleRefKindMismatch, "Role %s/%s does not exist, but a ClusterRole with that name does", bindingNamespace, ref.Name)
+	case ref.Kind == "ClusterRole" && !clusterRoleExists && roleExists:
+		issue(IssueRoleRefKindMismatch, "ClusterRole %s does not exist, but a Role with that name does in namespace %s", ref.Name, bindingNamespace)
+	case ref.Kind == "Role" && !roleExists:
+		issue(IssueDanglingRoleRef, "Role %s/%s does not exist", bindingNamespace, ref.Name)
+	case ref.Kind == "ClusterRole" && !clusterRoleExists:
+		issue(IssueDanglingRoleRef, "ClusterRole %s does not exist", ref.Name)
+	}
+
+	if len(subjects) == 0 {
+		issue(IssueNoSubjects, "binding has no subjects")
+	}
+	return issues
+}
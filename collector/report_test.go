@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckBindings(t *testing.T) {
+	roles := RoleRules{RoleKey("tenant", "viewer"): granted, RoleKey("tenant", "local"): nil}
+	clusterRoles := RoleRules{"view": granted}
+	alice := []v1r.Subject{{Kind: "User", Name: "alice"}}
+
+	roleBindings := v1r.RoleBindingList{
+		Items: []v1r.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "ok", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "Role", Name: "viewer"},
+				Subjects:   alice,
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "dangling", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "Role", Name: "missing"},
+				Subjects:   alice,
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "wrong-kind", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "Role", Name: "view"},
+				Subjects:   alice,
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+			},
+		},
+	}
+	clusterRoleBindings := v1r.ClusterRoleBindingList{
+		Items: []v1r.ClusterRoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "ok"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   alice,
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "dangling"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "edit"},
+				Subjects:   alice,
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "role-ref"},
+				RoleRef:    v1r.RoleRef{Kind: "Role", Name: "viewer"},
+				Subjects:   alice,
+			},
+		},
+	}
+
+	issues := CheckBindings(roles, clusterRoles, &roleBindings, &clusterRoleBindings)
+	kinds := make(map[string]IssueKind)
+	for _, issue := range issues {
+		assert.NotEmpty(t, issue.Message)
+		kinds[issue.BindingKind+"/"+issue.BindingName] = issue.Kind
+	}
+	assert.Equal(t, map[string]IssueKind{
+		"RoleBinding/dangling":        IssueDanglingRoleRef,
+		"RoleBinding/wrong-kind":      IssueRoleRefKindMismatch,
+		"RoleBinding/empty":           IssueNoSubjects,
+		"ClusterRoleBinding/dangling": IssueDanglingRoleRef,
+		"ClusterRoleBinding/role-ref": IssueRoleRefKindMismatch,
+	}, kinds)
+}
@@ -0,0 +1,23 @@
+package collector
+
+import v1r "k8s.io/api/rbac/v1"
+
+const (
+	serviceAccountUserPrefix = "system:serviceaccount:"
+	// serviceAccountGroup is the group of all ServiceAccounts, system:serviceaccounts:<namespace> the group of
+	// all ServiceAccounts in a namespace.
+	serviceAccountGroup = "system:serviceaccounts"
+)
+
+// serviceAccountUsername returns the name the API server authenticates a ServiceAccount subject as.
+// Subjects without a namespace fall back to the namespace of their binding, "" is returned if neither is set.
+func serviceAccountUsername(subject v1r.Subject, bindingNamespace string) string {
+	namespace := subject.Namespace
+	if namespace == "" {
+		namespace = bindingNamespace
+	}
+	if namespace == "" {
+		return ""
+	}
+	return serviceAccountUserPrefix + namespace + ":" + subject.Name
+}
@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServiceAccountUsername(t *testing.T) {
+	assert.Equal(t, "system:serviceaccount:monitoring:grafana", serviceAccountUsername(v1r.Subject{Name: "grafana", Namespace: "monitoring"}, "tenant"))
+	assert.Equal(t, "system:serviceaccount:tenant:ci", serviceAccountUsername(v1r.Subject{Name: "ci"}, "tenant"))
+	assert.Equal(t, "", serviceAccountUsername(v1r.Subject{Name: "ci"}, ""))
+}
+
+func TestCollectServiceAccounts(t *testing.T) {
+	roles := RoleRules{RoleKey("tenant", "viewer"): granted}
+	roleBindings := v1r.RoleBindingList{
+		Items: []v1r.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "Role", Name: "viewer"},
+				Subjects: []v1r.Subject{
+					{Kind: "ServiceAccount", Name: "grafana", Namespace: "monitoring"},
+					{Kind: "ServiceAccount", Name: "ci"},
+					{Kind: "Group", Name: "system:serviceaccounts:tenant"},
+					{Kind: "User", Name: "system:admin"},
+				},
+			},
+		},
+	}
+	clusterRoleBindings := v1r.ClusterRoleBindingList{
+		Items: []v1r.ClusterRoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "all-service-accounts"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects: []v1r.Subject{
+					{Kind: "Group", Name: "system:serviceaccounts"},
+					{Kind: "ServiceAccount", Name: "orphan"},
+				},
+			},
+		},
+	}
+	clusterRoles := RoleRules{"view": granted}
+
+	permissions := Collect(roles, clusterRoles, &roleBindings, &clusterRoleBindings, nil, DefaultConfig())
+	assert.Empty(t, permissions.Flat())
+
+	config := DefaultConfig()
+	config.ServiceAccounts = true
+	permissions = Collect(roles, clusterRoles, &roleBindings, &clusterRoleBindings, nil, config)
+	assert.Equal(t, map[string]map[string]bool{
+		"system:serviceaccount:monitoring:grafana": {"tenant": true},
+		"system:serviceaccount:tenant:ci":          {"tenant": true},
+	}, permissions.Users)
+	assert.Equal(t, map[string]map[string]bool{
+		"system:serviceaccounts:tenant": {"tenant": true},
+		"system:serviceaccounts":        {"#cluster-wide": true},
+	}, permissions.Groups)
+}
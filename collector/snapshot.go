@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	v1r "k8s.io/api/rbac/v1"
+)
+
+// DefaultProfile is the profile name of the permissions evaluated with Config.Matcher.
+const DefaultProfile = ""
+
+// Snapshot holds the RBAC objects of a cluster, if Config.NeedsNamespaces its Namespaces,
+// if Config.ResolvesGroups the members of its groups, the configured overrides and the active break-glass grants.
+type Snapshot struct {
+	Roles               *v1r.RoleList
+	ClusterRoles        *v1r.ClusterRoleList
+	RoleBindings        *v1r.RoleBindingList
+	ClusterRoleBindings *v1r.ClusterRoleBindingList
+	Namespaces          *v1.NamespaceList
+	Groups              GroupMembers
+	Overrides           Overrides
+	BreakGlass          []BreakGlassGrant
+}
+
+// Collect evaluates the roles with the matcher of the default profile and of every configured profile and
+// collects the permissions of each, keyed by profile name. ClusterRoles are aggregated only once.
+// Overrides and break-glass grants apply to every profile, deny entries are applied again after group expansion so they also
+// remove namespaces users inherit from their groups. With Config.Impersonation, the access subjects gain by
+// impersonation is added last as indirect entries.
+func (s Snapshot) Collect(config Config) map[string]Permissions {
+	clusterRoles := AggregateClusterRoles(*s.ClusterRoles)
+	matchers := map[string]Matcher{DefaultProfile: config.Matcher}
+	for name, matcher := range config.Profiles {
+		matchers[name] = matcher
+	}
+
+	var impersonators []*impersonator
+	if config.Impersonation {
+		impersonators = s.findImpersonators(clusterRoles, config)
+	}
+
+	permissions := make(map[string]Permissions, len(matchers))
+	for name, matcher := range matchers {
+		roles, clusterRoleRules := evaluateRoles(*s.Roles, clusterRoles, matcher)
+		p := Collect(roles, clusterRoleRules, s.RoleBindings, s.ClusterRoleBindings, s.Namespaces, config)
+		s.adjust(p, config, impersonators)
+		permissions[name] = p
+	}
+	return permissions
+}
+
+// adjust applies the overrides, break-glass grants, group members and impersonation of the snapshot to the
+// permissions collected from the bindings.
+func (s Snapshot) adjust(p Permissions, config Config, impersonators []*impersonator) {
+	p.Allow(s.Overrides)
+	p.AllowBreakGlass(s.BreakGlass)
+	p.Deny(s.Overrides)
+	if config.ResolvesGroups() {
+		p.ExpandGroups(s.Groups)
+		p.Deny(s.Overrides)
+	}
+	if config.Impersonation {
+		p.addImpersonation(impersonators)
+		p.Deny(s.Overrides)
+	}
+}
+
+// BindingGroups returns the sorted names of all Group subjects of the bindings.
+func (s Snapshot) BindingGroups() []string {
+	found := make(map[string]bool)
+	for _, rb := range s.RoleBindings.Items {
+		for _, subject := range rb.Subjects {
+			if subject.Kind == v1r.GroupKind {
+				found[subject.Name] = true
+			}
+		}
+	}
+	for _, crb := range s.ClusterRoleBindings.Items {
+		for _, subject := range crb.Subjects {
+			if subject.Kind == v1r.GroupKind {
+				found[subject.Name] = true
+			}
+		}
+	}
+	groups := make([]string, 0, len(found))
+	for group := range found {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// NextExpiry returns the next time a binding expires according to Config.ExpiryAnnotation, false if none does.
+func (s Snapshot) NextExpiry(config Config) (time.Time, bool) {
+	return NextBindingExpiry(s.RoleBindings, s.ClusterRoleBindings, config.ExpiryAnnotation, now())
+}
+
+// Check reports binding issues, see CheckBindings.
+func (s Snapshot) Check() []BindingIssue {
+	roles := make(RoleRules, len(s.Roles.Items))
+	for _, role := range s.Roles.Items {
+		roles[RoleKey(role.Namespace, role.Name)] = nil
+	}
+	clusterRoles := make(RoleRules, len(s.ClusterRoles.Items))
+	for _, clusterRole := range s.ClusterRoles.Items {
+		clusterRoles[clusterRole.Name] = nil
+	}
+	return CheckBindings(roles, clusterRoles, s.RoleBindings, s.ClusterRoleBindings)
+}
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSnapshotCollectProfiles(t *testing.T) {
+	snapshot := Snapshot{
+		Roles: &v1r.RoleList{Items: []v1r.Role{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "log-reader", Namespace: "tenant"},
+				Rules:      []v1r.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}}},
+			},
+		}},
+		ClusterRoles: &v1r.ClusterRoleList{Items: []v1r.ClusterRole{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "metrics-reader"},
+				Rules:      []v1r.PolicyRule{{APIGroups: []string{"metrics.k8s.io"}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+			},
+		}},
+		RoleBindings: &v1r.RoleBindingList{Items: []v1r.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "log-readers", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "Role", Name: "log-reader"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "metrics-readers", Namespace: "tenant"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "metrics-reader"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "bob"}},
+			},
+		}},
+		ClusterRoleBindings: &v1r.ClusterRoleBindingList{},
+	}
+
+	config := DefaultConfig()
+	config.Profiles = map[string]Matcher{
+		"logs":    {Permissions: []Permission{{APIGroup: "", Resource: "pods/log", Verb: "get"}}},
+		"metrics": {Permissions: []Permission{{APIGroup: "metrics.k8s.io", Resource: "*", Verb: "get"}}},
+	}
+	assert.NoError(t, config.Validate())
+
+	permissions := snapshot.Collect(config)
+	assert.Len(t, permissions, 3)
+	assert.Equal(t, map[string]map[string]bool{"alice": {"tenant": true}, "bob": {"tenant": true}}, permissions[DefaultProfile].Flat())
+	assert.Equal(t, map[string]map[string]bool{"alice": {"tenant": true}}, permissions["logs"].Flat())
+	assert.Equal(t, map[string]map[string]bool{"bob": {"tenant": true}}, permissions["metrics"].Flat())
+	assert.Empty(t, snapshot.Check())
+
+	config.Profiles = map[string]Matcher{"logs/all": {}}
+	assert.Error(t, config.Validate())
+}
+
+func TestSnapshotBindingGroups(t *testing.T) {
+	snapshot := Snapshot{
+		RoleBindings: &v1r.RoleBindingList{Items: []v1r.RoleBinding{
+			{Subjects: []v1r.Subject{{Kind: "Group", Name: "ops"}, {Kind: "User", Name: "alice"}}},
+		}},
+		ClusterRoleBindings: &v1r.ClusterRoleBindingList{Items: []v1r.ClusterRoleBinding{
+			{Subjects: []v1r.Subject{{Kind: "Group", Name: "devs"}, {Kind: "Group", Name: "ops"}}},
+		}},
+	}
+	assert.Equal(t, []string{"devs", "ops"}, snapshot.BindingGroups())
+}
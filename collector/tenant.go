@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// TenantFallbackNamespace writes the namespace name if a namespace has no tenant value.
+	TenantFallbackNamespace = "namespace"
+	// TenantFallbackDrop drops grants for namespaces without a tenant value.
+	TenantFallbackDrop = "drop"
+)
+
+// TenantMapping translates namespaces into the tenant label values multena-proxy injects into queries.
+// The value is read from the namespace label Label, the annotation Annotation or derived from the namespace name
+// by matching Regex and expanding Template (default "$0"). Exactly one source must be set.
+type TenantMapping struct {
+	Label      string `yaml:"label"`
+	Annotation string `yaml:"annotation"`
+	Regex      string `yaml:"regex"`
+	Template   string `yaml:"template"`
+	// Fallback is either TenantFallbackNamespace (default) or TenantFallbackDrop.
+	Fallback string `yaml:"fallback"`
+
+	regex *regexp.Regexp
+}
+
+// Enabled reports whether a tenant mapping is configured.
+func (m TenantMapping) Enabled() bool {
+	return m.Label != "" || m.Annotation != "" || m.Regex != ""
+}
+
+func (m *TenantMapping) compile() error {
+	if !m.Enabled() {
+		return nil
+	}
+	set := 0
+	for _, source := range []string{m.Label, m.Annotation, m.Regex} {
+		if source != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("tenant mapping: exactly one of label, annotation and regex must be set")
+	}
+	switch m.Fallback {
+	case "", TenantFallbackNamespace, TenantFallbackDrop:
+	default:
+		return fmt.Errorf("tenant mapping: unknown fallback %q", m.Fallback)
+	}
+	if m.Regex != "" {
+		regex, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return fmt.Errorf("tenant mapping: invalid regex %q: %w", m.Regex, err)
+		}
+		m.regex = regex
+	}
+	return nil
+}
+
+// Tenant returns the tenant value of the namespace, false if the namespace has none.
+func (m TenantMapping) Tenant(namespace v1.Namespace) (string, bool) {
+	switch {
+	case m.Label != "":
+		value, ok := namespace.Labels[m.Label]
+		return value, ok && value != ""
+	case m.Annotation != "":
+		value, ok := namespace.Annotations[m.Annotation]
+		return value, ok && value != ""
+	case m.Regex != "":
+		regex := m.regex
+		if regex == nil {
+			var err error
+			if regex, err = regexp.Compile(m.Regex); err != nil {
+				return "", false
+			}
+		}
+		match := regex.FindStringSubmatchIndex(namespace.Name)
+		if match == nil {
+			return "", false
+		}
+		template := m.Template
+		if template == "" {
+			template = "$0"
+		}
+		value := string(regex.ExpandString(nil, template, namespace.Name, match))
+		return value, value != ""
+	default:
+		return namespace.Name, true
+	}
+}
+
+// Tenants maps the names of all namespaces to their tenant value, namespaces without one are mapped according
+// to the fallback: to their own name, or not at all.
+func (m TenantMapping) Tenants(namespaces *v1.NamespaceList) map[string]string {
+	if namespaces == nil {
+		return map[string]string{}
+	}
+	tenants := make(map[string]string, len(namespaces.Items))
+	for _, namespace := range namespaces.Items {
+		if tenant, ok := m.Tenant(namespace); ok {
+			tenants[namespace.Name] = tenant
+		} else if m.Fallback != TenantFallbackDrop {
+			tenants[namespace.Name] = namespace.Name
+		}
+	}
+	return tenants
+}
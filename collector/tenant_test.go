@@ -0,0 +1,118 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	v1r "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTenantMapping(t *testing.T) {
+	namespaces := &v1.NamespaceList{
+		Items: []v1.Namespace{
+			{ObjectMeta: metav1.ObjectMeta{
+				Name:        "team-a-prod",
+				Labels:      map[string]string{"tenant": "a"},
+				Annotations: map[string]string{"openshift.io/requester": "alice"},
+			}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "shared"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		mapping  TenantMapping
+		expected map[string]string
+	}{
+		{
+			name:     "label",
+			mapping:  TenantMapping{Label: "tenant"},
+			expected: map[string]string{"team-a-prod": "a", "shared": "shared"},
+		},
+		{
+			name:     "annotation without fallback",
+			mapping:  TenantMapping{Annotation: "openshift.io/requester", Fallback: TenantFallbackDrop},
+			expected: map[string]string{"team-a-prod": "alice"},
+		},
+		{
+			name:     "regex with template",
+			mapping:  TenantMapping{Regex: `^team-([a-z]+)-`, Template: "tenant-$1"},
+			expected: map[string]string{"team-a-prod": "tenant-a", "shared": "shared"},
+		},
+		{
+			name:     "regex without template",
+			mapping:  TenantMapping{Regex: `^team-[a-z]+`},
+			expected: map[string]string{"team-a-prod": "team-a", "shared": "shared"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, tt.mapping.compile())
+			assert.Equal(t, tt.expected, tt.mapping.Tenants(namespaces))
+		})
+	}
+}
+
+func TestTenantMappingInvalid(t *testing.T) {
+	config := DefaultConfig()
+	config.Tenants = TenantMapping{Label: "tenant", Annotation: "tenant"}
+	assert.Error(t, config.Validate())
+
+	config.Tenants = TenantMapping{Label: "tenant", Fallback: "unknown"}
+	assert.Error(t, config.Validate())
+
+	config.Tenants = TenantMapping{Regex: "("}
+	assert.Error(t, config.Validate())
+}
+
+func TestCollectTenants(t *testing.T) {
+	roleBindings := v1r.RoleBindingList{
+		Items: []v1r.RoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers", Namespace: "team-a-prod"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers", Namespace: "team-a-dev"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "viewers", Namespace: "shared"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "alice"}},
+			},
+		},
+	}
+	clusterRoleBindings := v1r.ClusterRoleBindingList{
+		Items: []v1r.ClusterRoleBinding{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "auditors"},
+				RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects:   []v1r.Subject{{Kind: "User", Name: "bob"}},
+			},
+		},
+	}
+	namespaces := &v1.NamespaceList{
+		Items: []v1.Namespace{
+			{ObjectMeta: metav1.ObjectMeta{Name: "team-a-prod", Labels: map[string]string{"tenant": "a"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "team-a-dev", Labels: map[string]string{"tenant": "a"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "shared"}},
+		},
+	}
+
+	config := DefaultConfig()
+	config.Tenants = TenantMapping{Label: "tenant", Fallback: TenantFallbackDrop}
+	assert.NoError(t, config.Validate())
+	assert.True(t, config.NeedsNamespaces())
+
+	permissions := Collect(RoleRules{}, RoleRules{"view": granted}, &roleBindings, &clusterRoleBindings, namespaces, config)
+	assert.Equal(t, map[string]map[string]bool{
+		"alice": {"a": true},
+		"bob":   {ClusterWide: true},
+	}, permissions.Flat())
+}
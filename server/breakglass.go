@@ -0,0 +1,316 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gepaplexx/multena-rbac-collector/collector"
+	"github.com/gepaplexx/multena-rbac-collector/util"
+)
+
+// BreakGlassPath is the path of the break-glass API, grants are revoked at BreakGlassPath/<id>.
+const BreakGlassPath = "/breakglass/grants"
+
+// minBreakGlassDuration is the shortest grant, shorter ones would expire before the recompute they trigger.
+const minBreakGlassDuration = time.Minute
+
+// BreakGlass manages temporary grants: it persists them in a ConfigMap, removes them when they expire and
+// triggers a recompute on every change. Only the leader changes grants, followers serve them from the ConfigMap.
+type BreakGlass struct {
+	clientset kubernetes.Interface
+	config    collector.BreakGlassConfig
+	policy    RetryPolicy
+	health    *Health
+	scheduler *Scheduler
+	leading   func() bool
+
+	mu     sync.Mutex
+	loaded bool
+	grants []collector.BreakGlassGrant
+	timer  *time.Timer
+	// failures counts the failed attempts to persist expired grants
+	failures int
+}
+
+// breakGlassRequest is the body of a create request, Duration is a Go duration like 2h.
+type breakGlassRequest struct {
+	Kind       string   `json:"kind"`
+	Subject    string   `json:"subject"`
+	Namespaces []string `json:"namespaces"`
+	Reason     string   `json:"reason"`
+	Duration   string   `json:"duration"`
+}
+
+// NewBreakGlass creates the break-glass API, requests are rejected until Load read the persisted grants.
+func NewBreakGlass(clientset kubernetes.Interface, config collector.BreakGlassConfig, policy RetryPolicy, health *Health, scheduler *Scheduler, leading func() bool) *BreakGlass {
+	return &BreakGlass{clientset: clientset, config: config, policy: policy, health: health, scheduler: scheduler, leading: leading}
+}
+
+// Load reads the persisted grants, retrying until it succeeds or stop is closed, and schedules their expiry.
+// A new leader calls it again to pick up the changes of the previous leader.
+func (b *BreakGlass) Load(stop <-chan struct{}) {
+	var grants []collector.BreakGlassGrant
+	policy := b.policy
+	policy.Budget = 0
+	err := policy.Do(stop, func() (err error) {
+		grants, err = util.ReadBreakGlassGrants(b.clientset, b.config.ConfigMap)
+		return err
+	}, func(err error, failures int) {
+		log.Error().Err(err).Int("failures", failures).Msg("Error loading break-glass grants, retrying")
+		b.health.Failed("breakglass", err, failures)
+	})
+	if err != nil {
+		return
+	}
+	b.health.Succeeded("breakglass")
+	b.mu.Lock()
+	b.grants, b.loaded = grants, true
+	b.mu.Unlock()
+	b.expire()
+}
+
+// Active returns the grants that have not expired.
+func (b *BreakGlass) Active() []collector.BreakGlassGrant {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	active, _ := collector.ActiveBreakGlassGrants(b.grants, time.Now())
+	return active
+}
+
+// expire removes expired grants and schedules the next expiry, on followers it leaves the ConfigMap to the leader.
+func (b *BreakGlass) expire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	active, expired := collector.ActiveBreakGlassGrants(b.grants, time.Now())
+	if len(expired) > 0 && !b.leading() {
+		b.grants = active
+		b.scheduler.Notify(TriggerBreakGlass)
+	} else if len(expired) > 0 {
+		if err := util.WriteBreakGlassGrants(b.clientset, b.config.ConfigMap, active); err != nil {
+			b.failures++
+			log.Error().Err(err).Int("failures", b.failures).Msg("Error persisting break-glass grants, retrying")
+			b.health.Failed("breakglass", err, b.failures)
+			b.schedule(time.Now().Add(b.policy.Delay(b.failures)))
+			return
+		}
+		b.failures = 0
+		b.health.Succeeded("breakglass")
+		b.grants = active
+		for _, grant := range expired {
+			audit("expired", "", grant)
+		}
+		b.scheduler.Notify(TriggerBreakGlass)
+	}
+	if len(active) > 0 {
+		b.schedule(active[0].Expires)
+	}
+}
+
+// schedule runs expire at the given time, b.mu must be held.
+func (b *BreakGlass) schedule(at time.Time) {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.timer = time.AfterFunc(time.Until(at), b.expire)
+}
+
+func (b *BreakGlass) create(grant collector.BreakGlassGrant) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	grants := append(slices.Clone(b.grants), grant)
+	if err := util.WriteBreakGlassGrants(b.clientset, b.config.ConfigMap, grants); err != nil {
+		return err
+	}
+	b.grants = grants
+	if active, _ := collector.ActiveBreakGlassGrants(b.grants, time.Now()); len(active) > 0 {
+		b.schedule(active[0].Expires)
+	}
+	b.scheduler.Notify(TriggerBreakGlass)
+	return nil
+}
+
+func (b *BreakGlass) revoke(id string) (*collector.BreakGlassGrant, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := slices.IndexFunc(b.grants, func(grant collector.BreakGlassGrant) bool { return grant.ID == id })
+	if i < 0 {
+		return nil, nil
+	}
+	revoked := b.grants[i]
+	grants := slices.Delete(slices.Clone(b.grants), i, i+1)
+	if err := util.WriteBreakGlassGrants(b.clientset, b.config.ConfigMap, grants); err != nil {
+		return nil, err
+	}
+	b.grants = grants
+	b.scheduler.Notify(TriggerBreakGlass)
+	return &revoked, nil
+}
+
+// ServeHTTP lists (GET), creates (POST) and revokes (DELETE BreakGlassPath/<id>) grants.
+func (b *BreakGlass) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	loaded := b.loaded
+	b.mu.Unlock()
+	if !loaded {
+		http.Error(w, "grants not loaded yet", http.StatusServiceUnavailable)
+		return
+	}
+	user, err := b.authenticate(r)
+	if err != nil {
+		log.Warn().Err(err).Str("path", r.URL.Path).Msg("Rejected break-glass request")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !b.authorized(user) {
+		log.Warn().Str("user", user.Username).Msg("Rejected break-glass request")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, BreakGlassPath), "/")
+	switch {
+	case r.Method == http.MethodGet && id == "" && !b.leading():
+		// the leader may have changed the grants since they were loaded
+		grants, err := util.ReadBreakGlassGrants(b.clientset, b.config.ConfigMap)
+		if err != nil {
+			log.Error().Err(err).Msg("Error reading break-glass grants")
+			http.Error(w, "error reading grants", http.StatusInternalServerError)
+			return
+		}
+		active, _ := collector.ActiveBreakGlassGrants(grants, time.Now())
+		writeJSON(w, http.StatusOK, active)
+	case r.Method == http.MethodGet && id == "":
+		writeJSON(w, http.StatusOK, b.Active())
+	case (r.Method == http.MethodPost || r.Method == http.MethodDelete) && !b.leading():
+		http.Error(w, "not the leader, retry against the leader", http.StatusServiceUnavailable)
+	case r.Method == http.MethodPost && id == "":
+		b.handleCreate(w, r, user.Username)
+	case r.Method == http.MethodDelete && id != "":
+		revoked, err := b.revoke(id)
+		if err != nil {
+			log.Error().Err(err).Msg("Error persisting break-glass grants")
+			http.Error(w, "error persisting grants", http.StatusInternalServerError)
+			return
+		}
+		if revoked == nil {
+			http.Error(w, "grant not found", http.StatusNotFound)
+			return
+		}
+		audit("revoked", user.Username, *revoked)
+		writeJSON(w, http.StatusOK, revoked)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (b *BreakGlass) handleCreate(w http.ResponseWriter, r *http.Request, username string) {
+	var request breakGlassRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	duration, err := time.ParseDuration(request.Duration)
+	if err != nil || duration < minBreakGlassDuration || duration > b.config.MaxDuration {
+		http.Error(w, fmt.Sprintf("duration must be at least %s and at most %s", minBreakGlassDuration, b.config.MaxDuration), http.StatusBadRequest)
+		return
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, "error generating id", http.StatusInternalServerError)
+		return
+	}
+	var namespaces []string
+	for _, namespace := range request.Namespaces {
+		if !slices.Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	now := time.Now().UTC()
+	grant := collector.BreakGlassGrant{
+		ID:         hex.EncodeToString(id),
+		Kind:       request.Kind,
+		Subject:    request.Subject,
+		Namespaces: namespaces,
+		Reason:     request.Reason,
+		CreatedBy:  username,
+		Created:    now.Truncate(time.Second),
+		Expires:    now.Add(duration),
+	}
+	if err := grant.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := b.create(grant); err != nil {
+		log.Error().Err(err).Msg("Error persisting break-glass grants")
+		http.Error(w, "error persisting grants", http.StatusInternalServerError)
+		return
+	}
+	audit("created", username, grant)
+	writeJSON(w, http.StatusCreated, grant)
+}
+
+// authenticate reviews the bearer token of the request.
+func (b *BreakGlass) authenticate(r *http.Request) (authv1.UserInfo, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return authv1.UserInfo{}, fmt.Errorf("bearer token required")
+	}
+	review, err := b.clientset.AuthenticationV1().TokenReviews().Create(r.Context(), &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authv1.UserInfo{}, fmt.Errorf("error reviewing token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return authv1.UserInfo{}, fmt.Errorf("invalid token")
+	}
+	return review.Status.User, nil
+}
+
+func (b *BreakGlass) authorized(user authv1.UserInfo) bool {
+	if slices.Contains(b.config.AllowedUsers, user.Username) {
+		return true
+	}
+	for _, group := range user.Groups {
+		if slices.Contains(b.config.AllowedGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// audit logs a change of a break-glass grant, user is empty for changes by the collector itself.
+func audit(action, user string, grant collector.BreakGlassGrant) {
+	log.Info().
+		Bool("audit", true).
+		Str("action", action).
+		Str("user", user).
+		Str("id", grant.ID).
+		Str("kind", grant.Kind).
+		Str("subject", grant.Subject).
+		Strs("namespaces", grant.Namespaces).
+		Str("reason", grant.Reason).
+		Str("createdBy", grant.CreatedBy).
+		Time("expires", grant.Expires).
+		Msg("Break-glass grant " + action)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error().Err(err).Msg("Error writing response")
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	authv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/gepaplexx/multena-rbac-collector/collector"
+	"github.com/gepaplexx/multena-rbac-collector/util"
+)
+
+var breakGlassConfig = collector.BreakGlassConfig{
+	ConfigMap:     collector.ConfigMapReference{Name: "grants", Namespace: "multena", Key: collector.DefaultBreakGlassKey},
+	AllowedGroups: []string{"oncall"},
+	MaxDuration:   8 * time.Hour,
+}
+
+// newBreakGlass returns a loaded break-glass API on a fake clientset. The token "admin" authenticates a member
+// of the allowed group, "other" a user without access, every other token is invalid.
+func newBreakGlass(t *testing.T, leading bool, objects ...runtime.Object) (*BreakGlass, *fake.Clientset, *Scheduler) {
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview)
+		switch review.Spec.Token {
+		case "admin":
+			review.Status = authv1.TokenReviewStatus{Authenticated: true, User: authv1.UserInfo{Username: "alice", Groups: []string{"oncall"}}}
+		case "other":
+			review.Status = authv1.TokenReviewStatus{Authenticated: true, User: authv1.UserInfo{Username: "bob"}}
+		}
+		return true, review, nil
+	})
+	scheduler := NewScheduler(DefaultSchedulerOptions)
+	b := NewBreakGlass(clientset, breakGlassConfig, DefaultRetryPolicy, NewHealth(DefaultRetryPolicy), scheduler, func() bool { return leading })
+	b.Load(make(chan struct{}))
+	t.Cleanup(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+	})
+	return b, clientset, scheduler
+}
+
+func serveBreakGlass(b *BreakGlass, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, r)
+	return w
+}
+
+func persistedGrants(t *testing.T, clientset *fake.Clientset) []collector.BreakGlassGrant {
+	grants, err := util.ReadBreakGlassGrants(clientset, breakGlassConfig.ConfigMap)
+	assert.NoError(t, err)
+	return grants
+}
+
+func notified(scheduler *Scheduler) bool {
+	select {
+	case <-scheduler.pending:
+		return true
+	default:
+		return false
+	}
+}
+
+func grantsConfigMap(t *testing.T, grants ...collector.BreakGlassGrant) *v1.ConfigMap {
+	data, err := yaml.Marshal(grants)
+	assert.NoError(t, err)
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: breakGlassConfig.ConfigMap.Name, Namespace: breakGlassConfig.ConfigMap.Namespace},
+		Data:       map[string]string{breakGlassConfig.ConfigMap.Key: string(data)},
+	}
+}
+
+func grant(id string, expires time.Time) collector.BreakGlassGrant {
+	return collector.BreakGlassGrant{
+		ID:         id,
+		Kind:       "User",
+		Subject:    "oncall",
+		Namespaces: []string{"tenant-" + id},
+		Reason:     "INC-1",
+		CreatedBy:  "alice",
+		Created:    expires.Add(-time.Hour).UTC().Truncate(time.Second),
+		Expires:    expires.UTC().Truncate(time.Second),
+	}
+}
+
+func TestBreakGlassRejectsUntilLoaded(t *testing.T) {
+	b := NewBreakGlass(fake.NewSimpleClientset(), breakGlassConfig, DefaultRetryPolicy, NewHealth(DefaultRetryPolicy), NewScheduler(DefaultSchedulerOptions), func() bool { return true })
+	assert.Equal(t, http.StatusServiceUnavailable, serveBreakGlass(b, http.MethodGet, BreakGlassPath, "admin", "").Code)
+}
+
+func TestBreakGlassAuthentication(t *testing.T) {
+	b, _, _ := newBreakGlass(t, true)
+	assert.Equal(t, http.StatusUnauthorized, serveBreakGlass(b, http.MethodGet, BreakGlassPath, "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serveBreakGlass(b, http.MethodGet, BreakGlassPath, "invalid", "").Code)
+	assert.Equal(t, http.StatusForbidden, serveBreakGlass(b, http.MethodGet, BreakGlassPath, "other", "").Code)
+	assert.Equal(t, http.StatusOK, serveBreakGlass(b, http.MethodGet, BreakGlassPath, "admin", "").Code)
+}
+
+func TestBreakGlassCreateAndRevoke(t *testing.T) {
+	b, clientset, scheduler := newBreakGlass(t, true)
+
+	before := time.Now()
+	w := serveBreakGlass(b, http.MethodPost, BreakGlassPath, "admin", `{"kind":"User","subject":"bob","namespaces":["tenant-b","tenant-a","tenant-b"],"reason":"INC-42","duration":"2h"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created collector.BreakGlassGrant
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, "alice", created.CreatedBy)
+	assert.Equal(t, []string{"tenant-b", "tenant-a"}, created.Namespaces, "duplicates are dropped")
+	assert.Equal(t, created.Namespaces, persistedGrants(t, clientset)[0].Namespaces)
+	assert.False(t, created.Expires.Before(before.Add(2*time.Hour)), "grants must not expire early")
+	assert.True(t, notified(scheduler))
+	assert.Equal(t, []string{created.ID}, ids(persistedGrants(t, clientset)))
+	assert.Equal(t, []string{created.ID}, ids(b.Active()))
+
+	w = serveBreakGlass(b, http.MethodGet, BreakGlassPath, "admin", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed []collector.BreakGlassGrant
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	assert.Equal(t, []string{created.ID}, ids(listed))
+
+	assert.Equal(t, http.StatusNotFound, serveBreakGlass(b, http.MethodDelete, BreakGlassPath+"/unknown", "admin", "").Code)
+	assert.Equal(t, http.StatusOK, serveBreakGlass(b, http.MethodDelete, BreakGlassPath+"/"+created.ID, "admin", "").Code)
+	assert.True(t, notified(scheduler))
+	assert.Empty(t, persistedGrants(t, clientset))
+	assert.Empty(t, b.Active())
+	assert.Equal(t, http.StatusMethodNotAllowed, serveBreakGlass(b, http.MethodPut, BreakGlassPath, "admin", "").Code)
+}
+
+func TestBreakGlassRejectsInvalidGrants(t *testing.T) {
+	b, clientset, scheduler := newBreakGlass(t, true)
+	for _, body := range []string{
+		`{"kind":"User","subject":"bob","namespaces":["tenant-a"],"reason":"INC-42","duration":"500ms"}`,
+		`{"kind":"User","subject":"bob","namespaces":["tenant-a"],"reason":"INC-42","duration":"9h"}`,
+		`{"kind":"User","subject":"bob","namespaces":["tenant-a"],"duration":"2h"}`,
+		`{"kind":"ServiceAccount","subject":"bob","namespaces":["tenant-a"],"reason":"INC-42","duration":"2h"}`,
+		`{"kind":"User","subject":"bob","namespaces":["tenant-a",""],"reason":"INC-42","duration":"2h"}`,
+		`not json`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, serveBreakGlass(b, http.MethodPost, BreakGlassPath, "admin", body).Code, body)
+	}
+	assert.False(t, notified(scheduler))
+	assert.Empty(t, persistedGrants(t, clientset))
+}
+
+func TestBreakGlassExpiry(t *testing.T) {
+	now := time.Now()
+	b, clientset, scheduler := newBreakGlass(t, true, grantsConfigMap(t, grant("expired", now.Add(-time.Minute)), grant("active", now.Add(time.Hour))))
+	assert.Equal(t, []string{"active"}, ids(b.Active()))
+	assert.Equal(t, []string{"active"}, ids(persistedGrants(t, clientset)), "the leader removes expired grants")
+	assert.True(t, notified(scheduler))
+
+	b.mu.Lock()
+	b.grants = append(b.grants, grant("soon", now.Add(-time.Second)))
+	b.mu.Unlock()
+	b.expire()
+	assert.Equal(t, []string{"active"}, ids(b.Active()))
+}
+
+func TestBreakGlassFollower(t *testing.T) {
+	now := time.Now()
+	b, clientset, scheduler := newBreakGlass(t, false, grantsConfigMap(t, grant("expired", now.Add(-time.Minute)), grant("active", now.Add(time.Hour))))
+	assert.Equal(t, []string{"active"}, ids(b.Active()))
+	assert.Len(t, persistedGrants(t, clientset), 2, "followers leave the ConfigMap to the leader")
+	assert.True(t, notified(scheduler))
+
+	assert.Equal(t, http.StatusServiceUnavailable, serveBreakGlass(b, http.MethodPost, BreakGlassPath, "admin", `{"kind":"User","subject":"bob","namespaces":["tenant-a"],"reason":"INC-42","duration":"2h"}`).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serveBreakGlass(b, http.MethodDelete, BreakGlassPath+"/active", "admin", "").Code)
+
+	// grants created by the leader are listed from the ConfigMap
+	_, err := clientset.CoreV1().ConfigMaps("multena").Update(context.Background(), grantsConfigMap(t, grant("active", now.Add(time.Hour)), grant("leader", now.Add(2*time.Hour))), metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	w := serveBreakGlass(b, http.MethodGet, BreakGlassPath, "admin", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed []collector.BreakGlassGrant
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	assert.Equal(t, []string{"active", "leader"}, ids(listed))
+}
+
+func ids(grants []collector.BreakGlassGrant) []string {
+	ids := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		ids = append(ids, grant.ID)
+	}
+	return ids
+}
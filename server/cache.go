@@ -0,0 +1,388 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	v1r "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	rbaclisters "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/gepaplexx/multena-rbac-collector/collector"
+	"github.com/gepaplexx/multena-rbac-collector/util"
+)
+
+// WatchCache keeps the resources the permissions are computed from in shared informers. The informers relist
+// on expired resource versions on their own, failed lists and watches are retried with the retry policy and
+// tracked in the health. Every change and every resync triggers a recompute.
+type WatchCache struct {
+	resync    time.Duration
+	policy    RetryPolicy
+	health    *Health
+	scheduler *Scheduler
+	stop      <-chan struct{}
+
+	// changes holds the keys of the objects changed since the last Apply, per resource
+	mu      sync.Mutex
+	changes map[string]map[string]bool
+
+	informers           []cache.SharedIndexInformer
+	roles               rbaclisters.RoleLister
+	clusterRoles        rbaclisters.ClusterRoleLister
+	roleBindings        rbaclisters.RoleBindingLister
+	clusterRoleBindings rbaclisters.ClusterRoleBindingLister
+	namespaces          corelisters.NamespaceLister
+	overrides           corelisters.ConfigMapNamespaceLister
+	groups              cache.GenericLister
+}
+
+// NewWatchCache registers the informers the configuration needs, they run once Start is called.
+func NewWatchCache(clientset kubernetes.Interface, dynamicClient dynamic.Interface, config util.Config, resync time.Duration, policy RetryPolicy, health *Health, scheduler *Scheduler) *WatchCache {
+	c := &WatchCache{resync: resync, policy: policy, health: health, scheduler: scheduler, changes: make(map[string]map[string]bool)}
+	namespaced := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	rbac := clientset.RbacV1()
+
+	c.roles = rbaclisters.NewRoleLister(c.register("roles",
+		listWatch(rbac.Roles(metav1.NamespaceAll).List, rbac.Roles(metav1.NamespaceAll).Watch, fields.Everything()), &v1r.Role{}, namespaced))
+	c.clusterRoles = rbaclisters.NewClusterRoleLister(c.register("clusterroles",
+		listWatch(rbac.ClusterRoles().List, rbac.ClusterRoles().Watch, fields.Everything()), &v1r.ClusterRole{}, cache.Indexers{}))
+	c.roleBindings = rbaclisters.NewRoleBindingLister(c.register("rolebindings",
+		listWatch(rbac.RoleBindings(metav1.NamespaceAll).List, rbac.RoleBindings(metav1.NamespaceAll).Watch, fields.Everything()), &v1r.RoleBinding{}, namespaced))
+	c.clusterRoleBindings = rbaclisters.NewClusterRoleBindingLister(c.register("clusterrolebindings",
+		listWatch(rbac.ClusterRoleBindings().List, rbac.ClusterRoleBindings().Watch, fields.Everything()), &v1r.ClusterRoleBinding{}, cache.Indexers{}))
+
+	if config.Collector.NeedsNamespaces() {
+		namespaces := clientset.CoreV1().Namespaces()
+		c.namespaces = corelisters.NewNamespaceLister(c.register("namespaces",
+			listWatch(namespaces.List, namespaces.Watch, fields.Everything()), &v1.Namespace{}, cache.Indexers{}))
+	}
+	if ref := config.Collector.Overrides.ConfigMap; ref.Name != "" {
+		configMaps := clientset.CoreV1().ConfigMaps(ref.Namespace)
+		c.overrides = corelisters.NewConfigMapLister(c.register("overrides",
+			listWatch(configMaps.List, configMaps.Watch, fields.OneTermEqualSelector("metadata.name", ref.Name)), &v1.ConfigMap{}, namespaced)).ConfigMaps(ref.Namespace)
+	}
+	if config.Collector.ExpandGroups {
+		groups := dynamicClient.Resource(collector.OpenShiftGroupResource)
+		c.groups = cache.NewGenericLister(c.register("groups",
+			listWatch(groups.List, groups.Watch, fields.Everything()), &unstructured.Unstructured{}, cache.Indexers{}), collector.OpenShiftGroupResource.GroupResource())
+	}
+	return c
+}
+
+// listWatch lists and watches the objects of a typed or dynamic client matching the field selector.
+func listWatch[L runtime.Object](list func(context.Context, metav1.ListOptions) (L, error), watchFunc func(context.Context, metav1.ListOptions) (watch.Interface, error), selector fields.Selector) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = selector.String()
+			return list(context.Background(), opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = selector.String()
+			return watchFunc(context.Background(), opts)
+		},
+	}
+}
+
+// register creates an informer for the resource and returns its indexer for the lister.
+func (c *WatchCache) register(resource string, lw *cache.ListWatch, obj runtime.Object, indexers cache.Indexers) cache.Indexer {
+	informer := cache.NewSharedIndexInformer(c.retrying(resource, lw), obj, c.resync, indexers)
+	if _, err := informer.AddEventHandler(c.handler(resource)); err != nil {
+		// only fails for informers that were stopped already
+		log.Error().Err(err).Str("resource", resource).Msg("Error registering event handler")
+	}
+	if err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		// the reflector relists or rewatches after broken watches itself
+		log.Warn().Err(err).Str("resource", resource).Msg("Watch interrupted")
+	}); err != nil {
+		log.Error().Err(err).Str("resource", resource).Msg("Error registering watch error handler")
+	}
+	c.informers = append(c.informers, informer)
+	return informer.GetIndexer()
+}
+
+// handler records the changed objects for Apply and triggers a recompute on every event, resyncs are counted
+// apart from changes.
+func (c *WatchCache) handler(resource string) cache.ResourceEventHandler {
+	changed := func(obj any) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			log.Error().Err(err).Str("resource", resource).Msg("Error getting object key")
+			return
+		}
+		c.mu.Lock()
+		if c.changes[resource] == nil {
+			c.changes[resource] = make(map[string]bool)
+		}
+		c.changes[resource][key] = true
+		c.mu.Unlock()
+		c.scheduler.Notify(resource)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: changed,
+		UpdateFunc: func(oldObj, newObj any) {
+			oldMeta, oldErr := meta.Accessor(oldObj)
+			newMeta, newErr := meta.Accessor(newObj)
+			if oldErr == nil && newErr == nil && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+				c.scheduler.Notify(TriggerResync)
+				return
+			}
+			changed(newObj)
+		},
+		DeleteFunc: changed,
+	}
+}
+
+// Apply passes the RBAC objects and namespaces changed since the last call to the engine. Objects are looked up in
+// the cache when applied, so a burst of changes to one object is applied once with its current state.
+func (c *WatchCache) Apply(engine *collector.Engine) error {
+	c.mu.Lock()
+	changes := c.changes
+	c.changes = make(map[string]map[string]bool)
+	c.mu.Unlock()
+
+	err := errors.Join(
+		applyChanges(changes["roles"], func(namespace, name string) (*v1r.Role, error) {
+			return c.roles.Roles(namespace).Get(name)
+		}, engine.SetRole, engine.DeleteRole),
+		applyChanges(changes["clusterroles"], func(_, name string) (*v1r.ClusterRole, error) {
+			return c.clusterRoles.Get(name)
+		}, engine.SetClusterRole, func(_, name string) { engine.DeleteClusterRole(name) }),
+		applyChanges(changes["rolebindings"], func(namespace, name string) (*v1r.RoleBinding, error) {
+			return c.roleBindings.RoleBindings(namespace).Get(name)
+		}, engine.SetRoleBinding, engine.DeleteRoleBinding),
+		applyChanges(changes["clusterrolebindings"], func(_, name string) (*v1r.ClusterRoleBinding, error) {
+			return c.clusterRoleBindings.Get(name)
+		}, engine.SetClusterRoleBinding, func(_, name string) { engine.DeleteClusterRoleBinding(name) }),
+	)
+	if c.namespaces != nil {
+		err = errors.Join(err, applyChanges(changes["namespaces"], func(_, name string) (*v1.Namespace, error) {
+			return c.namespaces.Get(name)
+		}, engine.SetNamespace, func(_, name string) { engine.DeleteNamespace(name) }))
+	}
+	return err
+}
+
+// Pending reports whether objects changed since the last Apply.
+func (c *WatchCache) Pending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.changes) > 0
+}
+
+// applyChanges sets a deep copy of every changed object found in the cache and removes the others.
+func applyChanges[T interface{ DeepCopy() T }](keys map[string]bool, get func(namespace, name string) (T, error), set func(T), remove func(namespace, name string)) error {
+	var errs []error
+	for key := range keys {
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		obj, err := get(namespace, name)
+		switch {
+		case apierrors.IsNotFound(err):
+			remove(namespace, name)
+		case err != nil:
+			errs = append(errs, err)
+		default:
+			set(obj.DeepCopy())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retrying wraps the list and watch calls with the retry policy. Expired resource versions are returned to the
+// reflector at once, it relists from the current state. Other errors are retried for as long as the cache runs,
+// the resource is degraded once the budget is exhausted.
+func (c *WatchCache) retrying(resource string, lw *cache.ListWatch) *cache.ListWatch {
+	failed := func(err error, failures int) {
+		log.Error().Err(err).Str("resource", resource).Int("failures", failures).Msg("Error listing or watching resource, retrying")
+		c.health.Failed(resource, err, failures)
+	}
+	retry := func(op func() error) error {
+		policy := c.policy
+		policy.Budget = 0
+		// count the failures against the budget for the health, but keep retrying
+		return policy.Do(c.stop, func() error {
+			err := op()
+			if err == nil {
+				c.health.Succeeded(resource)
+			}
+			if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+				return nil
+			}
+			return err
+		}, failed)
+	}
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (list runtime.Object, err error) {
+			retryErr := retry(func() error {
+				list, err = lw.List(opts)
+				return err
+			})
+			return list, errors.Join(retryErr, err)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (w watch.Interface, err error) {
+			retryErr := retry(func() error {
+				w, err = lw.Watch(opts)
+				return err
+			})
+			return w, errors.Join(retryErr, err)
+		},
+	}
+}
+
+// Start runs the informers until stop is closed.
+func (c *WatchCache) Start(stop <-chan struct{}) {
+	c.stop = stop
+	for _, informer := range c.informers {
+		go informer.Run(stop)
+	}
+}
+
+// WaitForSync blocks until every informer has listed its resources once, it returns false if stop was closed before.
+func (c *WatchCache) WaitForSync(stop <-chan struct{}) bool {
+	synced := make([]cache.InformerSynced, 0, len(c.informers))
+	for _, informer := range c.informers {
+		synced = append(synced, informer.HasSynced)
+	}
+	return cache.WaitForCacheSync(stop, synced...)
+}
+
+// Synced reports whether every informer has listed its resources once.
+func (c *WatchCache) Synced() bool {
+	for _, informer := range c.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot copies the cached resources. The informers keep updating their stores while the snapshot is collected,
+// so the objects are deep copies the collector may hold on to.
+func (c *WatchCache) Snapshot() (collector.Snapshot, error) {
+	snapshot := collector.Snapshot{
+		Roles:               &v1r.RoleList{},
+		ClusterRoles:        &v1r.ClusterRoleList{},
+		RoleBindings:        &v1r.RoleBindingList{},
+		ClusterRoleBindings: &v1r.ClusterRoleBindingList{},
+	}
+	roles, err := c.roles.List(labels.Everything())
+	if err != nil {
+		return collector.Snapshot{}, err
+	}
+	snapshot.Roles.Items = make([]v1r.Role, 0, len(roles))
+	for _, role := range roles {
+		snapshot.Roles.Items = append(snapshot.Roles.Items, *role.DeepCopy())
+	}
+	clusterRoles, err := c.clusterRoles.List(labels.Everything())
+	if err != nil {
+		return collector.Snapshot{}, err
+	}
+	snapshot.ClusterRoles.Items = make([]v1r.ClusterRole, 0, len(clusterRoles))
+	for _, clusterRole := range clusterRoles {
+		snapshot.ClusterRoles.Items = append(snapshot.ClusterRoles.Items, *clusterRole.DeepCopy())
+	}
+	roleBindings, err := c.roleBindings.List(labels.Everything())
+	if err != nil {
+		return collector.Snapshot{}, err
+	}
+	snapshot.RoleBindings.Items = make([]v1r.RoleBinding, 0, len(roleBindings))
+	for _, roleBinding := range roleBindings {
+		snapshot.RoleBindings.Items = append(snapshot.RoleBindings.Items, *roleBinding.DeepCopy())
+	}
+	clusterRoleBindings, err := c.clusterRoleBindings.List(labels.Everything())
+	if err != nil {
+		return collector.Snapshot{}, err
+	}
+	snapshot.ClusterRoleBindings.Items = make([]v1r.ClusterRoleBinding, 0, len(clusterRoleBindings))
+	for _, clusterRoleBinding := range clusterRoleBindings {
+		snapshot.ClusterRoleBindings.Items = append(snapshot.ClusterRoleBindings.Items, *clusterRoleBinding.DeepCopy())
+	}
+	if c.namespaces != nil {
+		namespaces, err := c.namespaces.List(labels.Everything())
+		if err != nil {
+			return collector.Snapshot{}, err
+		}
+		snapshot.Namespaces = &v1.NamespaceList{Items: make([]v1.Namespace, 0, len(namespaces))}
+		for _, namespace := range namespaces {
+			snapshot.Namespaces.Items = append(snapshot.Namespaces.Items, *namespace.DeepCopy())
+		}
+	}
+	if c.groups != nil {
+		if snapshot.Groups, err = c.Groups(); err != nil {
+			return collector.Snapshot{}, err
+		}
+	}
+	sortSnapshot(snapshot)
+	return snapshot, nil
+}
+
+// Groups returns the members of the cached OpenShift Groups, nil if groups are not expanded.
+func (c *WatchCache) Groups() (collector.GroupMembers, error) {
+	if c.groups == nil {
+		return nil, nil
+	}
+	groups, err := c.groups.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	list := &unstructured.UnstructuredList{}
+	for _, group := range groups {
+		u, ok := group.(*unstructured.Unstructured)
+		if !ok {
+			return nil, fmt.Errorf("unexpected group type %T", group)
+		}
+		list.Items = append(list.Items, *u.DeepCopy())
+	}
+	return collector.OpenShiftGroups(list), nil
+}
+
+// sortSnapshot orders the objects by namespace and name like collector.Engine.Snapshot, the listers return
+// them in random order.
+func sortSnapshot(snapshot collector.Snapshot) {
+	byKey := func(a, b metav1.Object) int {
+		return cmp.Compare(collector.RoleKey(a.GetNamespace(), a.GetName()), collector.RoleKey(b.GetNamespace(), b.GetName()))
+	}
+	slices.SortFunc(snapshot.Roles.Items, func(a, b v1r.Role) int { return byKey(&a, &b) })
+	slices.SortFunc(snapshot.ClusterRoles.Items, func(a, b v1r.ClusterRole) int { return cmp.Compare(a.Name, b.Name) })
+	slices.SortFunc(snapshot.RoleBindings.Items, func(a, b v1r.RoleBinding) int { return byKey(&a, &b) })
+	slices.SortFunc(snapshot.ClusterRoleBindings.Items, func(a, b v1r.ClusterRoleBinding) int { return cmp.Compare(a.Name, b.Name) })
+	if snapshot.Namespaces != nil {
+		slices.SortFunc(snapshot.Namespaces.Items, func(a, b v1.Namespace) int { return cmp.Compare(a.Name, b.Name) })
+	}
+}
+
+// Overrides reads the overrides from the cached ConfigMap or, for a file, from disk.
+func (c *WatchCache) Overrides(clientset kubernetes.Interface, source collector.OverridesSource) (collector.Overrides, error) {
+	if c.overrides == nil {
+		return util.ReadOverrides(clientset, source)
+	}
+	cm, err := c.overrides.Get(source.ConfigMap.Name)
+	if apierrors.IsNotFound(err) {
+		return collector.Overrides{}, fmt.Errorf("overrides ConfigMap %s/%s not found", source.ConfigMap.Namespace, source.ConfigMap.Name)
+	}
+	if err != nil {
+		return collector.Overrides{}, err
+	}
+	return util.OverridesFromConfigMap(cm, source.ConfigMap.Key)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1r "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/gepaplexx/multena-rbac-collector/collector"
+	"github.com/gepaplexx/multena-rbac-collector/util"
+)
+
+var testRetryPolicy = RetryPolicy{Initial: time.Millisecond, Max: 5 * time.Millisecond, Factor: 2, Budget: 2}
+
+func TestWatchCacheApply(t *testing.T) {
+	view := []v1r.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}}
+	clientset := fake.NewSimpleClientset(
+		&v1r.Role{ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "a"}, Rules: view},
+		&v1r.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "view"}, Rules: view},
+		&v1r.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "view", Namespace: "a"},
+			RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+			Subjects:   []v1r.Subject{{Kind: v1r.UserKind, Name: "alice"}},
+		},
+		&v1r.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "view"},
+			RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+			Subjects:   []v1r.Subject{{Kind: v1r.UserKind, Name: "bob"}},
+		},
+	)
+	config := util.Config{Collector: collector.DefaultConfig()}
+	c := NewWatchCache(clientset, nil, config, 0, testRetryPolicy, NewHealth(testRetryPolicy), NewScheduler(DefaultSchedulerOptions))
+	stop := make(chan struct{})
+	defer close(stop)
+	c.Start(stop)
+	assert.True(t, c.WaitForSync(stop))
+	assert.True(t, c.Synced())
+
+	engine := collector.NewEngine(config.Collector)
+	assert.True(t, c.Pending(), "the initial list is pending")
+	assert.NoError(t, c.Apply(engine))
+	assert.False(t, c.Pending())
+	snapshot, err := c.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot, engine.Snapshot())
+	assert.Equal(t, map[string]bool{"a": true}, engine.Collect(snapshot)[collector.DefaultProfile].Users["alice"])
+
+	rb := &v1r.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "view", Namespace: "b", ResourceVersion: "1"},
+		RoleRef:    v1r.RoleRef{Kind: "ClusterRole", Name: "view"},
+		Subjects:   []v1r.Subject{{Kind: v1r.UserKind, Name: "alice"}},
+	}
+	_, err = clientset.RbacV1().RoleBindings("b").Create(context.Background(), rb, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	rb.ResourceVersion, rb.Subjects = "2", append(rb.Subjects, v1r.Subject{Kind: v1r.UserKind, Name: "carol"})
+	_, err = clientset.RbacV1().RoleBindings("b").Update(context.Background(), rb, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, clientset.RbacV1().ClusterRoleBindings().Delete(context.Background(), "view", metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.changes["rolebindings"]) == 1 && len(c.changes["clusterrolebindings"]) == 1
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, c.Apply(engine))
+	snapshot, err = c.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot, engine.Snapshot())
+	permissions := engine.Collect(snapshot)[collector.DefaultProfile]
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, permissions.Users["alice"])
+	assert.Equal(t, map[string]bool{"b": true}, permissions.Users["carol"], "the current state of the cache is applied")
+	assert.NotContains(t, permissions.Users, "bob")
+}
+
+func TestWatchCacheRetrying(t *testing.T) {
+	health := NewHealth(testRetryPolicy)
+	c := &WatchCache{policy: testRetryPolicy, health: health}
+	stop := make(chan struct{})
+	c.stop = stop
+
+	calls := 0
+	lw := c.retrying("roles", &cache.ListWatch{ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+		calls++
+		if calls < 4 {
+			return nil, errors.New("unavailable")
+		}
+		return &v1r.RoleList{}, nil
+	}})
+	list, err := lw.List(metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &v1r.RoleList{}, list)
+	assert.Equal(t, 4, calls, "retried beyond the budget")
+	assert.False(t, health.Degraded(), "the success clears the degraded state")
+
+	calls = 0
+	expired := apierrors.NewResourceExpired("too old resource version")
+	lw = c.retrying("roles", &cache.ListWatch{ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+		calls++
+		return nil, expired
+	}})
+	_, err = lw.List(metav1.ListOptions{})
+	assert.True(t, apierrors.IsResourceExpired(err))
+	assert.Equal(t, 1, calls, "expired resource versions are returned to the reflector at once")
+
+	gone := apierrors.NewGone("gone")
+	lw = c.retrying("roles", &cache.ListWatch{ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+		return nil, gone
+	}})
+	_, err = lw.List(metav1.ListOptions{})
+	assert.True(t, apierrors.IsGone(err))
+
+	lw = c.retrying("rolebindings", &cache.ListWatch{ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+		return nil, apierrors.NewForbidden(schema.GroupResource{Resource: "rolebindings"}, "", errors.New("denied"))
+	}})
+	time.AfterFunc(50*time.Millisecond, func() { close(stop) })
+	_, err = lw.List(metav1.ListOptions{})
+	assert.ErrorIs(t, err, errStopped)
+	assert.True(t, apierrors.IsForbidden(err))
+	assert.True(t, health.Degraded())
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ResourceState is the state of listing, watching or writing one resource.
+type ResourceState struct {
+	Resource string `json:"resource"`
+	// Degraded is set once the retry budget is exhausted and cleared by the next success.
+	Degraded    bool      `json:"degraded"`
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastFailure time.Time `json:"lastFailure,omitempty"`
+	LastSuccess time.Time `json:"lastSuccess,omitempty"`
+}
+
+// Health tracks the state of every resource, the collector is degraded while any resource is.
+type Health struct {
+	policy RetryPolicy
+
+	mu        sync.Mutex
+	resources map[string]*ResourceState
+}
+
+// healthStatus is the body of the health endpoints.
+type healthStatus struct {
+	Status    string          `json:"status"`
+	Synced    bool            `json:"synced"`
+	Resources []ResourceState `json:"resources"`
+}
+
+func NewHealth(policy RetryPolicy) *Health {
+	return &Health{policy: policy, resources: make(map[string]*ResourceState)}
+}
+
+func (h *Health) state(resource string) *ResourceState {
+	state, ok := h.resources[resource]
+	if !ok {
+		state = &ResourceState{Resource: resource}
+		h.resources[resource] = state
+	}
+	return state
+}
+
+// Succeeded resets the failures of the resource.
+func (h *Health) Succeeded(resource string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state := h.state(resource)
+	state.Degraded, state.Failures, state.LastSuccess = false, 0, time.Now()
+	resourceDegraded.WithLabelValues(resource).Set(0)
+}
+
+// Failed records a failed attempt, failures counts the consecutive failed attempts.
+func (h *Health) Failed(resource string, err error, failures int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state := h.state(resource)
+	state.Failures, state.LastError, state.LastFailure = failures, err.Error(), time.Now()
+	retries.WithLabelValues(resource).Inc()
+	if h.policy.Exhausted(failures) {
+		state.Degraded = true
+		resourceDegraded.WithLabelValues(resource).Set(1)
+	}
+}
+
+// Degraded reports whether any resource is degraded.
+func (h *Health) Degraded() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, state := range h.resources {
+		if state.Degraded {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Health) states() []ResourceState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	states := make([]ResourceState, 0, len(h.resources))
+	for _, state := range h.resources {
+		states = append(states, *state)
+	}
+	slices.SortFunc(states, func(a, b ResourceState) int { return cmp.Compare(a.Resource, b.Resource) })
+	return states
+}
+
+// handler writes the state of every resource. The status is ok, syncing until synced reports true or degraded,
+// unhealthy answers with 503 if ready is set, liveness checks only fail if the server stops answering.
+func (h *Health) handler(synced func() bool, ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := healthStatus{Status: "ok", Synced: synced(), Resources: h.states()}
+		switch {
+		case h.Degraded():
+			status.Status = "degraded"
+		case !status.Synced:
+			status.Status = "syncing"
+		}
+		code := http.StatusOK
+		if ready && status.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, status)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandler(t *testing.T) {
+	health := NewHealth(RetryPolicy{Budget: 2})
+	synced := false
+	check := func(ready bool) (int, healthStatus) {
+		w := httptest.NewRecorder()
+		health.handler(func() bool { return synced }, ready)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		var status healthStatus
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+		return w.Code, status
+	}
+
+	code, status := check(true)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "syncing", status.Status)
+	code, _ = check(false)
+	assert.Equal(t, http.StatusOK, code, "liveness ignores the sync")
+
+	synced = true
+	code, status = check(true)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", status.Status)
+
+	health.Failed("configmap", errors.New("forbidden"), 1)
+	code, status = check(true)
+	assert.Equal(t, http.StatusOK, code, "failures within the budget are not degraded")
+	if assert.Len(t, status.Resources, 1) {
+		assert.Equal(t, "forbidden", status.Resources[0].LastError)
+		assert.Equal(t, 1, status.Resources[0].Failures)
+	}
+
+	health.Failed("configmap", errors.New("forbidden"), 2)
+	code, status = check(true)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "degraded", status.Status)
+	assert.True(t, status.Resources[0].Degraded)
+	code, _ = check(false)
+	assert.Equal(t, http.StatusOK, code, "liveness ignores degraded resources")
+
+	health.Succeeded("configmap")
+	code, status = check(true)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", status.Status)
+	assert.Equal(t, 0, status.Resources[0].Failures)
+}
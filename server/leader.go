@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElectionOptions configures the Lease replicas compete for, only the holder writes outputs.
+type LeaderElectionOptions struct {
+	Enabled        bool
+	LeaseName      string
+	LeaseNamespace string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
+	// Identity names the replica in the Lease, it defaults to the POD_NAME environment variable or the hostname.
+	Identity string
+}
+
+// DefaultLeaderElectionOptions are used for the settings not given as flags.
+var DefaultLeaderElectionOptions = LeaderElectionOptions{
+	LeaseName:     "multena-rbac-collector",
+	LeaseDuration: 15 * time.Second,
+	RenewDeadline: 10 * time.Second,
+	RetryPeriod:   2 * time.Second,
+}
+
+// Validate reports the first invalid setting if leader election is enabled, with the constraints of the
+// leader elector checked before serving starts.
+func (o LeaderElectionOptions) Validate() error {
+	switch {
+	case !o.Enabled:
+		return nil
+	case o.LeaseName == "":
+		return fmt.Errorf("leader election: lease name must be set")
+	case o.LeaseDuration < time.Second:
+		// the Lease records the duration in whole seconds, shorter leases would be taken over at once
+		return fmt.Errorf("leader election: lease duration must be at least 1s")
+	case o.RenewDeadline >= o.LeaseDuration:
+		return fmt.Errorf("leader election: renew deadline must be shorter than the lease duration")
+	case o.RetryPeriod <= 0:
+		return fmt.Errorf("leader election: retry period must be positive")
+	case float64(o.RenewDeadline) <= leaderelection.JitterFactor*float64(o.RetryPeriod):
+		return fmt.Errorf("leader election: renew deadline must be longer than %v times the retry period", leaderelection.JitterFactor)
+	}
+	return nil
+}
+
+// Leader tracks whether this replica holds the Lease. Without leader election every replica leads.
+type Leader struct {
+	options LeaderElectionOptions
+	leading atomic.Bool
+}
+
+func NewLeader(options LeaderElectionOptions) *Leader {
+	if !options.Enabled {
+		leaderGauge.Set(1)
+	}
+	return &Leader{options: options}
+}
+
+// Leading reports whether this replica may write outputs.
+func (l *Leader) Leading() bool {
+	return !l.options.Enabled || l.leading.Load()
+}
+
+// Run competes for the Lease until ctx is done. started is called whenever this replica acquires the Lease,
+// after losing it the replica keeps running as a follower and competes again. The options must be valid.
+func (l *Leader) Run(ctx context.Context, clientset kubernetes.Interface, started func()) error {
+	options := l.options
+	identity := options.Identity
+	if identity == "" {
+		identity = os.Getenv("POD_NAME")
+	}
+	if identity == "" {
+		var err error
+		if identity, err = os.Hostname(); err != nil {
+			return fmt.Errorf("leader election: error getting identity: %w", err)
+		}
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: options.LeaseName, Namespace: options.LeaseNamespace},
+			Client:     clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   options.LeaseDuration,
+		RenewDeadline:   options.RenewDeadline,
+		RetryPeriod:     options.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            options.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				log.Info().Str("identity", identity).Msg("Acquired lease, writing outputs")
+				l.leading.Store(true)
+				leaderGauge.Set(1)
+				started()
+			},
+			OnStoppedLeading: func() {
+				log.Warn().Str("identity", identity).Msg("Lost lease, following")
+				l.leading.Store(false)
+				leaderGauge.Set(0)
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					log.Info().Str("leader", current).Msg("Following leader")
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("leader election: %w", err)
+	}
+	for ctx.Err() == nil {
+		// Run returns once the lease is lost
+		elector.Run(ctx)
+	}
+	return nil
+}
+
+// documentWriter writes the documents of every recompute that changed them, as long as the replica leads. A new
+// leader writes its first documents even if they did not change, the previous leader may have written others.
+type documentWriter struct {
+	leading func() bool
+	persist func(documents map[string]string) error
+	// current holds the documents last written, nil if they are unknown
+	current    map[string]string
+	wasLeading bool
+}
+
+// Write persists the documents unless they were written already or the replica follows, it reports whether
+// they were written.
+func (w *documentWriter) Write(documents map[string]string) (bool, error) {
+	isLeading := w.leading()
+	if isLeading && !w.wasLeading {
+		w.current = nil
+	}
+	w.wasLeading = isLeading
+	if !isLeading || w.current != nil && maps.Equal(w.current, documents) {
+		return false, nil
+	}
+	if err := w.persist(documents); err != nil {
+		return false, err
+	}
+	w.current = documents
+	return true, nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderDisabled(t *testing.T) {
+	assert.True(t, NewLeader(LeaderElectionOptions{}).Leading(), "without leader election every replica leads")
+	assert.False(t, NewLeader(LeaderElectionOptions{Enabled: true}).Leading())
+}
+
+func TestLeaderElection(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	options := LeaderElectionOptions{
+		Enabled:        true,
+		LeaseName:      "rbac-collector",
+		LeaseNamespace: "multena",
+		// the Lease records whole seconds
+		LeaseDuration: 2 * time.Second,
+		RenewDeadline: time.Second,
+		RetryPeriod:   50 * time.Millisecond,
+	}
+	run := func(identity string) (*Leader, *atomic.Int32, context.CancelFunc, chan struct{}) {
+		options := options
+		options.Identity = identity
+		leader := NewLeader(options)
+		var started atomic.Int32
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			assert.NoError(t, leader.Run(ctx, clientset, func() { started.Add(1) }))
+		}()
+		return leader, &started, cancel, done
+	}
+
+	a, startedA, cancelA, doneA := run("a")
+	assert.Eventually(t, a.Leading, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), startedA.Load())
+	b, startedB, cancelB, doneB := run("b")
+	defer func() {
+		cancelB()
+		<-doneB
+	}()
+	time.Sleep(200 * time.Millisecond)
+	assert.False(t, b.Leading(), "the lease is held by a")
+
+	// a releases the lease on shutdown, b takes over and starts writing
+	cancelA()
+	<-doneA
+	assert.False(t, a.Leading())
+	assert.Eventually(t, b.Leading, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), startedB.Load())
+	lease, err := clientset.CoordinationV1().Leases("multena").Get(context.Background(), "rbac-collector", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "b", *lease.Spec.HolderIdentity)
+}
+
+func TestDocumentWriter(t *testing.T) {
+	leading := false
+	var written []map[string]string
+	var failure error
+	w := &documentWriter{leading: func() bool { return leading }, persist: func(documents map[string]string) error {
+		if failure != nil {
+			return failure
+		}
+		written = append(written, documents)
+		return nil
+	}}
+	a := map[string]string{"labels.yaml": "a"}
+	b := map[string]string{"labels.yaml": "b"}
+
+	ok, err := w.Write(a)
+	assert.NoError(t, err)
+	assert.False(t, ok, "followers do not write")
+
+	leading = true
+	ok, _ = w.Write(a)
+	assert.True(t, ok, "a new leader writes at once")
+	ok, _ = w.Write(a)
+	assert.False(t, ok, "unchanged documents are not written again")
+	ok, _ = w.Write(b)
+	assert.True(t, ok)
+
+	leading = false
+	ok, _ = w.Write(a)
+	assert.False(t, ok, "followers do not write")
+
+	leading = true
+	ok, _ = w.Write(b)
+	assert.True(t, ok, "a new leader writes documents it wrote before, another leader may have overwritten them")
+	assert.Equal(t, []map[string]string{a, b, b}, written)
+
+	failure = errors.New("forbidden")
+	ok, err = w.Write(a)
+	assert.Equal(t, failure, err)
+	assert.False(t, ok)
+	failure = nil
+	ok, _ = w.Write(a)
+	assert.True(t, ok, "failed writes are retried by the next recompute")
+}
+
+func TestLeaderElectionOptionsValidate(t *testing.T) {
+	assert.NoError(t, LeaderElectionOptions{}.Validate(), "disabled leader election is not checked")
+	valid := DefaultLeaderElectionOptions
+	valid.Enabled = true
+	assert.NoError(t, valid.Validate())
+
+	for name, modify := range map[string]func(o *LeaderElectionOptions){
+		"no lease name": func(o *LeaderElectionOptions) { o.LeaseName = "" },
+		"sub-second lease": func(o *LeaderElectionOptions) {
+			o.LeaseDuration, o.RenewDeadline = 500*time.Millisecond, 100*time.Millisecond
+		},
+		"renew deadline too long": func(o *LeaderElectionOptions) { o.RenewDeadline = o.LeaseDuration },
+		"no retry period":         func(o *LeaderElectionOptions) { o.RetryPeriod = 0 },
+		"retry period too long":   func(o *LeaderElectionOptions) { o.RetryPeriod = o.RenewDeadline },
+	} {
+		options := valid
+		modify(&options)
+		assert.Error(t, options.Validate(), name)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gepaplexx/multena-rbac-collector/collector"
+)
+
+const metricsNamespace = "multena_rbac_collector"
+
+var bindingIssues = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "binding_issues",
+	Help:      "Number of bindings with dangling RoleRefs, RoleRef kind mismatches or without subjects.",
+}, []string{"kind"})
+
+var excludedSubjects = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "excluded_subjects",
+	Help:      "Number of binding subjects dropped by each exclusion in the default profile.",
+}, []string{"exclusion"})
+
+var triggers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "triggers_total",
+	Help:      "Number of recompute triggers by source, informer events are counted by resource.",
+}, []string{"source"})
+
+var recomputes = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "recomputes_total",
+	Help:      "Number of permission recomputes the triggers were coalesced into.",
+})
+
+var consistencyMismatches = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "consistency_mismatches_total",
+	Help:      "Number of incremental recomputes that differed from a full recompute in consistency check mode.",
+})
+
+var resourceDegraded = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "resource_degraded",
+	Help:      "Whether listing, watching or writing a resource exhausted its retry budget.",
+}, []string{"resource"})
+
+var retries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "retries_total",
+	Help:      "Number of failed attempts to list, watch or write a resource.",
+}, []string{"resource"})
+
+var leaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "leader",
+	Help:      "Whether this replica writes outputs, it holds the lease or leader election is disabled.",
+})
+
+// reportExcluded sets the excluded subjects metric and removes the exclusions of reported no longer dropping
+// subjects. It returns the exclusions to pass as reported on the next call.
+func reportExcluded(excluded, reported map[string]int) map[string]int {
+	for exclusion, count := range excluded {
+		excludedSubjects.WithLabelValues(exclusion).Set(float64(count))
+	}
+	for exclusion := range reported {
+		if _, ok := excluded[exclusion]; !ok {
+			excludedSubjects.DeleteLabelValues(exclusion)
+		}
+	}
+	return excluded
+}
+
+// reportIssues logs every binding issue not contained in reported and updates the binding issue metric.
+// It returns the issues to pass as reported on the next call.
+func reportIssues(issues []collector.BindingIssue, reported map[collector.BindingIssue]bool) map[collector.BindingIssue]bool {
+	counts := make(map[collector.IssueKind]int, len(collector.IssueKinds))
+	current := make(map[collector.BindingIssue]bool, len(issues))
+	for _, issue := range issues {
+		counts[issue.Kind]++
+		current[issue] = true
+		if reported[issue] {
+			continue
+		}
+		log.Warn().
+			Str("kind", string(issue.Kind)).
+			Str("binding", issue.BindingKind+" "+issue.BindingNamespace+"/"+issue.BindingName).
+			Str("roleRef", issue.RoleKind+" "+issue.RoleName).
+			Msg(issue.Message)
+	}
+	for _, kind := range collector.IssueKinds {
+		bindingIssues.WithLabelValues(string(kind)).Set(float64(counts[kind]))
+	}
+	return current
+}
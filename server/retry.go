@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// errStopped is returned by RetryPolicy.Do when stop was closed while waiting for the next attempt.
+var errStopped = errors.New("stopped")
+
+// RetryPolicy retries failed API calls with exponential backoff. The delay starts at Initial, grows by Factor up
+// to Max and is randomized by ±Jitter (a fraction of the delay). Budget is the number of attempts an operation
+// gets before it counts as failed.
+type RetryPolicy struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+	Jitter  float64
+	Budget  int
+}
+
+// DefaultRetryPolicy is used for the settings not given as flags.
+var DefaultRetryPolicy = RetryPolicy{
+	Initial: time.Second,
+	Max:     2 * time.Minute,
+	Factor:  2,
+	Jitter:  0.2,
+	Budget:  5,
+}
+
+// Validate reports the first setting that would retry without backing off.
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.Initial <= 0:
+		return fmt.Errorf("retry: initial delay must be positive")
+	case p.Max < p.Initial:
+		return fmt.Errorf("retry: maximum delay must not be shorter than the initial delay")
+	case p.Factor < 1:
+		return fmt.Errorf("retry: factor must be at least 1")
+	case p.Jitter < 0 || p.Jitter >= 1:
+		return fmt.Errorf("retry: jitter must be at least 0 and less than 1")
+	}
+	return nil
+}
+
+// Delay returns the time to wait after the given number of failed attempts.
+func (p RetryPolicy) Delay(failures int) time.Duration {
+	delay := float64(p.Initial) * math.Pow(p.Factor, float64(max(failures-1, 0)))
+	if delay > float64(p.Max) {
+		delay = float64(p.Max)
+	}
+	delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// Exhausted reports whether the given number of failed attempts used up the budget.
+func (p RetryPolicy) Exhausted(failures int) bool {
+	return p.Budget > 0 && failures >= p.Budget
+}
+
+// Do calls op until it succeeds, the budget is exhausted or stop is closed, it returns the last error.
+// failed is called after every failed attempt.
+func (p RetryPolicy) Do(stop <-chan struct{}, op func() error, failed func(err error, failures int)) error {
+	for failures := 1; ; failures++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		if failed != nil {
+			failed(err, failures)
+		}
+		if p.Exhausted(failures) {
+			return err
+		}
+		select {
+		case <-stop:
+			return errors.Join(errStopped, err)
+		case <-time.After(p.Delay(failures)):
+		}
+	}
+}
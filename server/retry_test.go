@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Initial: time.Second, Max: 10 * time.Second, Factor: 2}
+	assert.Equal(t, time.Second, policy.Delay(0))
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 2*time.Second, policy.Delay(2))
+	assert.Equal(t, 8*time.Second, policy.Delay(4))
+	assert.Equal(t, 10*time.Second, policy.Delay(5), "capped at Max")
+	assert.Equal(t, 10*time.Second, policy.Delay(100))
+
+	policy.Jitter = 0.2
+	for i := 0; i < 1000; i++ {
+		assert.InDelta(t, float64(4*time.Second), float64(policy.Delay(3)), float64(800*time.Millisecond))
+		assert.InDelta(t, float64(10*time.Second), float64(policy.Delay(10)), float64(2*time.Second))
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{Initial: time.Millisecond, Max: time.Millisecond, Factor: 2, Budget: 3}
+	failure := errors.New("unavailable")
+
+	var failures []int
+	err := policy.Do(nil, func() error { return failure }, func(err error, n int) {
+		assert.Equal(t, failure, err)
+		failures = append(failures, n)
+	})
+	assert.Equal(t, failure, err, "gives up once the budget is exhausted")
+	assert.Equal(t, []int{1, 2, 3}, failures)
+
+	calls := 0
+	err = policy.Do(nil, func() error {
+		if calls++; calls < 3 {
+			return failure
+		}
+		return nil
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	policy.Budget = 0
+	stop := make(chan struct{})
+	time.AfterFunc(20*time.Millisecond, func() { close(stop) })
+	calls = 0
+	err = policy.Do(stop, func() error {
+		calls++
+		return failure
+	}, nil)
+	assert.ErrorIs(t, err, errStopped)
+	assert.ErrorIs(t, err, failure)
+	assert.Greater(t, calls, 3, "retries without budget until stopped")
+}
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	assert.False(t, RetryPolicy{Budget: 0}.Exhausted(100))
+	assert.False(t, RetryPolicy{Budget: 5}.Exhausted(4))
+	assert.True(t, RetryPolicy{Budget: 5}.Exhausted(5))
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	assert.NoError(t, DefaultRetryPolicy.Validate())
+	for name, modify := range map[string]func(p *RetryPolicy){
+		"no initial delay":       func(p *RetryPolicy) { p.Initial = 0 },
+		"negative initial delay": func(p *RetryPolicy) { p.Initial = -time.Second },
+		"max below initial":      func(p *RetryPolicy) { p.Max = p.Initial / 2 },
+		"shrinking delay":        func(p *RetryPolicy) { p.Factor = 0.5 },
+		"jitter of the delay":    func(p *RetryPolicy) { p.Jitter = 1 },
+	} {
+		policy := DefaultRetryPolicy
+		modify(&policy)
+		assert.Error(t, policy.Validate(), name)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"time"
+)
+
+// Trigger sources counted in the triggers metric, informer events are counted by resource.
+const (
+	TriggerInitial    = "initial"
+	TriggerInvoke     = "invoke"
+	TriggerPeriodic   = "periodic"
+	TriggerResync     = "resync"
+	TriggerLDAP       = "ldap"
+	TriggerExpiry     = "expiry"
+	TriggerBreakGlass = "breakglass"
+	TriggerLeader     = "leader"
+)
+
+// SchedulerOptions configures when triggers are turned into recomputes.
+type SchedulerOptions struct {
+	// Debounce is the quiet period after the last trigger before recomputing.
+	Debounce time.Duration
+	// MinInterval is the minimum time between the start of two recomputes.
+	MinInterval time.Duration
+	// MaxInterval bounds the time a trigger waits for its recompute, a steady stream of triggers cuts the
+	// debounce short after it.
+	MaxInterval time.Duration
+	// Period forces a recompute without any trigger, zero disables it.
+	Period time.Duration
+}
+
+// DefaultSchedulerOptions are used for the settings not given as flags.
+var DefaultSchedulerOptions = SchedulerOptions{
+	Debounce:    2 * time.Second,
+	MinInterval: 5 * time.Second,
+	MaxInterval: 30 * time.Second,
+	Period:      time.Hour,
+}
+
+// Scheduler coalesces triggers into recomputes. Notify never blocks: any number of triggers while a recompute is
+// pending or running results in one more recompute.
+type Scheduler struct {
+	options SchedulerOptions
+	pending chan struct{}
+	forced  chan struct{}
+}
+
+func NewScheduler(options SchedulerOptions) *Scheduler {
+	return &Scheduler{options: options, pending: make(chan struct{}, 1), forced: make(chan struct{}, 1)}
+}
+
+// Notify requests a recompute, source labels the trigger in the metrics.
+func (s *Scheduler) Notify(source string) {
+	triggers.WithLabelValues(source).Inc()
+	select {
+	case s.pending <- struct{}{}:
+	default:
+		// a recompute is pending already
+	}
+}
+
+// Force requests a recompute that skips the debounce and the minimum interval.
+func (s *Scheduler) Force(source string) {
+	triggers.WithLabelValues(source).Inc()
+	select {
+	case s.forced <- struct{}{}:
+	default:
+	}
+}
+
+// Run calls recompute for the triggers until stop is closed.
+func (s *Scheduler) Run(stop <-chan struct{}, recompute func()) {
+	var period <-chan time.Time
+	if s.options.Period > 0 {
+		ticker := time.NewTicker(s.options.Period)
+		defer ticker.Stop()
+		period = ticker.C
+	}
+	var last time.Time
+	for {
+		periodic, forced := false, false
+		select {
+		case <-stop:
+			return
+		case <-s.pending:
+		case <-period:
+			triggers.WithLabelValues(TriggerPeriodic).Inc()
+			periodic = true
+		case <-s.forced:
+			forced = true
+		}
+		if !periodic && !forced && !s.debounce(stop, time.Now().Add(s.options.MaxInterval)) {
+			return
+		}
+		if !forced && !last.IsZero() && !sleep(stop, time.Until(last.Add(s.options.MinInterval))) {
+			return
+		}
+		// the recompute reads the current state, it covers the triggers received while waiting
+		select {
+		case <-s.pending:
+		default:
+		}
+		last = time.Now()
+		recomputes.Inc()
+		recompute()
+	}
+}
+
+// debounce waits until no trigger arrived for the debounce period or the deadline passed, it returns false if
+// stop was closed.
+func (s *Scheduler) debounce(stop <-chan struct{}, deadline time.Time) bool {
+	for {
+		timer := time.NewTimer(min(s.options.Debounce, time.Until(deadline)))
+		select {
+		case <-stop:
+			timer.Stop()
+			return false
+		case <-s.pending:
+			timer.Stop()
+			if !time.Now().Before(deadline) {
+				return true
+			}
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// sleep waits for d, it returns false if stop was closed.
+func sleep(stop <-chan struct{}, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
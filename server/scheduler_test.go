@@ -0,0 +1,99 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// runScheduler runs the scheduler until the test ends and returns the start times of its recomputes.
+func runScheduler(t *testing.T, options SchedulerOptions) (*Scheduler, func() []time.Time) {
+	s := NewScheduler(options)
+	var mu sync.Mutex
+	var started []time.Time
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(stop, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			started = append(started, time.Now())
+		})
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	return s, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), started...)
+	}
+}
+
+func TestSchedulerCoalescesTriggers(t *testing.T) {
+	before := testutil.ToFloat64(recomputes)
+	invoked := testutil.ToFloat64(triggers.WithLabelValues(TriggerInvoke))
+	s, recomputed := runScheduler(t, SchedulerOptions{Debounce: 20 * time.Millisecond, MaxInterval: time.Second})
+	for i := 0; i < 100; i++ {
+		s.Notify(TriggerInvoke)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, recomputed(), 1)
+	assert.Equal(t, 100.0, testutil.ToFloat64(triggers.WithLabelValues(TriggerInvoke))-invoked)
+	assert.Equal(t, 1.0, testutil.ToFloat64(recomputes)-before)
+}
+
+func TestSchedulerDebounce(t *testing.T) {
+	s, recomputed := runScheduler(t, SchedulerOptions{Debounce: 50 * time.Millisecond, MaxInterval: time.Second})
+	notified := time.Now()
+	s.Notify(TriggerInvoke)
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, recomputed(), "waits for the quiet period")
+	assert.Eventually(t, func() bool { return len(recomputed()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.GreaterOrEqual(t, recomputed()[0].Sub(notified), 50*time.Millisecond)
+}
+
+func TestSchedulerMaxInterval(t *testing.T) {
+	before := testutil.ToFloat64(recomputes)
+	s, recomputed := runScheduler(t, SchedulerOptions{Debounce: 50 * time.Millisecond, MaxInterval: 60 * time.Millisecond})
+	// a steady stream of triggers never leaves a quiet period
+	for deadline := time.Now().Add(300 * time.Millisecond); time.Now().Before(deadline); {
+		s.Notify(TriggerInvoke)
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.GreaterOrEqual(t, len(recomputed()), 3)
+	assert.LessOrEqual(t, len(recomputed()), 6)
+	assert.Equal(t, float64(len(recomputed())), testutil.ToFloat64(recomputes)-before)
+}
+
+func TestSchedulerMinInterval(t *testing.T) {
+	s, recomputed := runScheduler(t, SchedulerOptions{Debounce: time.Millisecond, MinInterval: 100 * time.Millisecond, MaxInterval: time.Second})
+	s.Notify(TriggerInvoke)
+	assert.Eventually(t, func() bool { return len(recomputed()) == 1 }, time.Second, time.Millisecond)
+	s.Notify(TriggerInvoke)
+	assert.Eventually(t, func() bool { return len(recomputed()) == 2 }, time.Second, time.Millisecond)
+	started := recomputed()
+	assert.GreaterOrEqual(t, started[1].Sub(started[0]), 100*time.Millisecond)
+}
+
+func TestSchedulerPeriod(t *testing.T) {
+	before := testutil.ToFloat64(triggers.WithLabelValues(TriggerPeriodic))
+	_, recomputed := runScheduler(t, SchedulerOptions{Debounce: time.Second, MaxInterval: time.Second, Period: 20 * time.Millisecond})
+	assert.Eventually(t, func() bool { return len(recomputed()) >= 3 }, time.Second, 5*time.Millisecond, "periodic recomputes skip the debounce")
+	assert.GreaterOrEqual(t, testutil.ToFloat64(triggers.WithLabelValues(TriggerPeriodic))-before, 3.0)
+}
+
+func TestSchedulerForce(t *testing.T) {
+	s, recomputed := runScheduler(t, SchedulerOptions{Debounce: time.Second, MinInterval: time.Second, MaxInterval: time.Second})
+	start := time.Now()
+	s.Force(TriggerLeader)
+	assert.Eventually(t, func() bool { return len(recomputed()) == 1 }, 500*time.Millisecond, time.Millisecond)
+	s.Force(TriggerLeader)
+	assert.Eventually(t, func() bool { return len(recomputed()) == 2 }, 500*time.Millisecond, time.Millisecond)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "forced recomputes skip the debounce and the minimum interval")
+}
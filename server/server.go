@@ -1,28 +1,53 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 
 	"github.com/gepaplexx/multena-rbac-collector/collector"
 	"github.com/gepaplexx/multena-rbac-collector/util"
-	v1r "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
-func Serve(clientset *kubernetes.Clientset, port int, config util.Config) {
-	signal := make(chan struct{}, 100000)
+// Options configures serve mode.
+type Options struct {
+	Port int
+	// Resync is the interval the informers replay their caches at, every resync recomputes the permissions.
+	Resync time.Duration
+	// Retry is the policy for listing, watching and writing resources.
+	Retry RetryPolicy
+	// Scheduler configures when changes are recomputed.
+	Scheduler SchedulerOptions
+	// ConsistencyCheck verifies every incremental recompute against a full recompute.
+	ConsistencyCheck bool
+	// LeaderElection lets only the replica holding the Lease write outputs, the namespace defaults to the one of
+	// the ConfigMap.
+	LeaderElection LeaderElectionOptions
+}
 
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "Ok")
-	})
+func Serve(clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, options Options, config util.Config) {
+	scheduler := NewScheduler(options.Scheduler)
+	health := NewHealth(options.Retry)
+	watchCache := NewWatchCache(clientset, dynamicClient, config, options.Resync, options.Retry, health, scheduler)
+	if options.LeaderElection.LeaseNamespace == "" {
+		options.LeaderElection.LeaseNamespace = config.CMNamespace
+	}
+	leader := NewLeader(options.LeaderElection)
+
+	// the collector keeps running while degraded, only readiness reports it
+	http.HandleFunc("/healthz", health.handler(watchCache.Synced, false))
+	http.HandleFunc("/readyz", health.handler(watchCache.Synced, true))
+
+	http.Handle("/metrics", promhttp.Handler())
 
 	http.HandleFunc("/invoke", func(w http.ResponseWriter, r *http.Request) {
-		signal <- struct{}{}
+		scheduler.Notify(TriggerInvoke)
 		_, err := fmt.Fprintf(w, "Invoked")
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
@@ -31,9 +56,33 @@ func Serve(clientset *kubernetes.Clientset, port int, config util.Config) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	go Watch(clientset, signal, config)
+	var breakGlass *BreakGlass
+	if config.Collector.BreakGlass.Enabled() {
+		log.Warn().Int("port", options.Port).Msg("Serving the break-glass API over plain HTTP, bearer tokens must only reach it through TLS")
+		breakGlass = NewBreakGlass(clientset, config.Collector.BreakGlass, options.Retry, health, scheduler, leader.Leading)
+		http.Handle(BreakGlassPath, breakGlass)
+		http.Handle(BreakGlassPath+"/", breakGlass)
+	}
+
+	if options.LeaderElection.Enabled {
+		go func() {
+			ctx := context.Background()
+			err := leader.Run(ctx, clientset, func() {
+				// the previous leader may have changed the grants, the recompute writes without waiting for a change
+				if breakGlass != nil {
+					breakGlass.Load(ctx.Done())
+				}
+				scheduler.Force(TriggerLeader)
+			})
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error running leader election")
+			}
+		}()
+	}
+
+	go Watch(clientset, watchCache, scheduler, leader, options.Retry, health, options.ConsistencyCheck, config, breakGlass)
 	// Start the server
-	err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	err := http.ListenAndServe(fmt.Sprintf(":%d", options.Port), nil)
 	if err != nil {
 		return
 	}
@@ -41,44 +90,169 @@ func Serve(clientset *kubernetes.Clientset, port int, config util.Config) {
 	select {}
 }
 
-func Watch(clientset *kubernetes.Clientset, signal chan struct{}, config util.Config) {
-	crbList := ResourceListWrapper{&v1r.ClusterRoleBindingList{}}
-	go watchResources(&ClusterRoleBindingAdapter{client: clientset}, &crbList, signal)
-
-	rbList := ResourceListWrapper{&v1r.RoleBindingList{}}
-	go watchResources(&RoleBindingAdapter{client: clientset}, &rbList, signal)
-
-	roleList := ResourceListWrapper{&v1r.RoleList{}}
-	go watchResources(&RoleAdapter{client: clientset}, &roleList, signal)
+// Watch recomputes the permissions on the triggers of the scheduler once the caches are synced and the
+// break-glass grants loaded.
+// The permissions are maintained incrementally, with consistencyCheck every result is verified against a full recompute.
+// Followers recompute as well to stay warm, only the leader writes the ConfigMap.
+func Watch(clientset kubernetes.Interface, watchCache *WatchCache, scheduler *Scheduler, leader *Leader, policy RetryPolicy, health *Health, consistencyCheck bool, config util.Config, breakGlass *BreakGlass) {
+	stop := make(chan struct{})
+	watchCache.Start(stop)
+	log.Info().Msg("Waiting for caches to sync")
+	watchCache.WaitForSync(stop)
+	log.Info().Msg("Caches synced")
+	if breakGlass != nil {
+		breakGlass.Load(stop)
+	}
 
-	crList := ResourceListWrapper{&v1r.ClusterRoleList{}}
-	go watchResources(&ClusterRoleAdapter{client: clientset}, &crList, signal)
+	var resolver *collector.LDAPResolver
+	if config.Collector.LDAP.Enabled() {
+		// the bind password may be mounted late, dropping the members would revoke access
+		retryForever := policy
+		retryForever.Budget = 0
+		err := retryForever.Do(stop, func() (err error) {
+			resolver, err = collector.NewLDAPResolver(config.Collector.LDAP)
+			return err
+		}, func(err error, failures int) {
+			log.Error().Err(err).Int("failures", failures).Msg("Error creating LDAP resolver, retrying")
+			health.Failed("ldap", err, failures)
+		})
+		if err != nil {
+			return
+		}
+		health.Succeeded("ldap")
+		// group members are re-resolved once their cache entries expired
+		go func() {
+			for range time.Tick(config.Collector.LDAP.CacheTTL) {
+				scheduler.Notify(TriggerLDAP)
+			}
+		}()
+	}
 
-	time.AfterFunc(2*time.Second, func() { signal <- struct{}{} })
+	scheduler.Notify(TriggerInitial)
 
-	currentPermission := make(map[string]map[string]bool, 1000)
+	engine := collector.NewEngine(config.Collector)
+	writer := &documentWriter{leading: leader.Leading, persist: func(documents map[string]string) error {
+		return policy.Do(stop, func() error {
+			return util.WriteConfigmap(clientset, documents, config)
+		}, func(err error, failures int) {
+			log.Error().Err(err).Int("failures", failures).Msg("Error writing configmap")
+			health.Failed("configmap", err, failures)
+		})
+	}}
+	var overrides collector.Overrides
+	overridesLoaded, overridesFailures := false, 0
+	var expiryTimer *time.Timer
+	reportedIssues := make(map[collector.BindingIssue]bool)
+	var reportedExcluded map[string]int
 
-	for range signal {
-		log.Debug().Msg("received signal")
-		roles, clusterRoles := collector.GetRoles(*roleList.List.(*v1r.RoleList), *crList.List.(*v1r.ClusterRoleList))
-		permissions := collector.Collect(roles, clusterRoles, rbList.List.(*v1r.RoleBindingList), crbList.List.(*v1r.ClusterRoleBindingList))
-		if !util.MapsEqual(currentPermission, permissions) {
-			currentPermission = permissions
-			err := util.WriteConfigmap(clientset, permissions, config)
+	scheduler.Run(stop, func() {
+		log.Debug().Msg("recomputing permissions")
+		if err := watchCache.Apply(engine); err != nil {
+			log.Error().Err(err).Msg("Error applying changed resources")
+		}
+		snapshot := engine.Snapshot()
+		groups, err := watchCache.Groups()
+		if err != nil {
+			log.Error().Err(err).Msg("Error reading cached groups")
+			return
+		}
+		snapshot.Groups = groups
+		if resolver != nil {
+			members, err := resolver.Resolve(snapshot.BindingGroups())
 			if err != nil {
-				log.Fatal().Err(err).Msg("Error writing configmap")
+				log.Error().Err(err).Msg("Error resolving groups in LDAP, using cached members")
+			}
+			snapshot.Groups = snapshot.Groups.Merge(members)
+		}
+		if config.Collector.Overrides.Enabled() {
+			current, err := watchCache.Overrides(clientset, config.Collector.Overrides)
+			switch {
+			case err != nil && !overridesLoaded:
+				// writing without the deny entries would grant the access they revoke
+				overridesFailures++
+				log.Error().Err(err).Int("failures", overridesFailures).Msg("Error reading overrides, not writing until they are loaded")
+				health.Failed("overrides", err, overridesFailures)
 				return
+			case err != nil:
+				overridesFailures++
+				log.Error().Err(err).Int("failures", overridesFailures).Msg("Error reading overrides, using previous overrides")
+				health.Failed("overrides", err, overridesFailures)
+			default:
+				overrides, overridesLoaded, overridesFailures = current, true, 0
+				health.Succeeded("overrides")
 			}
+			snapshot.Overrides = overrides
+		}
+		if breakGlass != nil {
+			snapshot.BreakGlass = breakGlass.Active()
+		}
+		reportedIssues = reportIssues(engine.Check(), reportedIssues)
+		permissions := engine.Collect(snapshot)
+		reportedExcluded = reportExcluded(engine.Excluded(), reportedExcluded)
+		if consistencyCheck {
+			permissions = checkConsistency(watchCache, snapshot, permissions, config)
+		}
+		if expiryTimer != nil {
+			expiryTimer.Stop()
+		}
+		if next, ok := engine.NextExpiry(); ok {
+			// recompute when the next binding expires, even if no watch event fires
+			log.Debug().Time("next", next).Msg("scheduled recompute at next binding expiry")
+			expiryTimer = time.AfterFunc(time.Until(next), func() { scheduler.Notify(TriggerExpiry) })
+		}
+		documents, err := util.Documents(permissions, config.Collector)
+		if err != nil {
+			log.Error().Err(err).Msg("Error rendering permissions")
+			return
+		}
+		written, err := writer.Write(documents)
+		if err != nil {
+			// the next trigger retries, at the latest the periodic recompute
+			log.Error().Err(err).Msg("Giving up writing configmap")
+		} else if written {
+			health.Succeeded("configmap")
 			log.Info().Msg("Configmap updated")
 		}
-		for range signal {
-			// this lets one signal in the channel for the rare occurrence that while draining the channel a new signal is received
-			if len(signal) > 1 {
-				<-signal
-			} else {
-				break
-			}
+	})
+}
+
+// checkConsistency recomputes the permissions from a full copy of the cache and compares their documents to
+// those of the incremental result. It returns the full result, which is written in case of a mismatch.
+func checkConsistency(watchCache *WatchCache, snapshot collector.Snapshot, incremental map[string]collector.Permissions, config util.Config) map[string]collector.Permissions {
+	full, err := watchCache.Snapshot()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading cached resources for the consistency check")
+		return incremental
+	}
+	full.Groups, full.Overrides, full.BreakGlass = snapshot.Groups, snapshot.Overrides, snapshot.BreakGlass
+	expected := full.Collect(config.Collector)
+	if watchCache.Pending() {
+		// the cache changed since the changes were applied, the next recompute checks again
+		log.Debug().Msg("Skipped consistency check of outdated permissions")
+		return expected
+	}
+	expectedDocuments, err := util.Documents(expected, config.Collector)
+	if err != nil {
+		return expected
+	}
+	actualDocuments, err := util.Documents(incremental, config.Collector)
+	if err != nil {
+		return expected
+	}
+	var differing []string
+	for key, document := range expectedDocuments {
+		if actualDocuments[key] != document {
+			differing = append(differing, key)
 		}
-		time.Sleep(5 * time.Second)
 	}
+	for key := range actualDocuments {
+		if _, ok := expectedDocuments[key]; !ok {
+			differing = append(differing, key)
+		}
+	}
+	if len(differing) > 0 {
+		consistencyMismatches.Inc()
+		log.Error().Strs("documents", differing).Msg("Incremental permissions differ from a full recompute, using the full recompute")
+	}
+	return expected
 }
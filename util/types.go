@@ -1,6 +1,9 @@
 package util
 
+import "github.com/gepaplexx/multena-rbac-collector/collector"
+
 type Config struct {
 	CMName      string
 	CMNamespace string
+	Collector   collector.Config
 }
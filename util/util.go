@@ -2,44 +2,131 @@ package util
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
+
 	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
-	"strings"
 
+	"github.com/gepaplexx/multena-rbac-collector/collector"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
-func WriteConfigmap(clientset *kubernetes.Clientset, permission map[string]map[string]bool, c Config) error {
-	permissions, err := yaml.Marshal(permission)
+const (
+	LabelsKey     = "labels.yaml"
+	ProvenanceKey = "provenance.yaml"
+	IndirectKey   = "indirect.yaml"
+)
+
+// DocumentKey returns the ConfigMap key of a document of the given profile, e.g. labels-logs.yaml.
+func DocumentKey(key, profile string) string {
+	if profile == collector.DefaultProfile {
+		return key
+	}
+	return strings.TrimSuffix(key, ".yaml") + "-" + profile + ".yaml"
+}
+
+// Documents renders the permissions of every profile into the documents written to the ConfigMap, keyed by their
+// ConfigMap key: labels.yaml in the configured output format and, if enabled, indirect.yaml in the same format and
+// provenance.yaml. Documents of profiles other than the default profile carry the profile name in their key, see
+// DocumentKey.
+func Documents(permissions map[string]collector.Permissions, c collector.Config) (map[string]string, error) {
+	documents := make(map[string]string, 2*len(permissions))
+	for profile, permission := range permissions {
+		document, err := permission.Document(c.Format)
+		if err != nil {
+			return nil, err
+		}
+		labels, err := yaml.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+		documents[DocumentKey(LabelsKey, profile)] = string(labels)
+
+		if c.Impersonation {
+			document, err := permission.Indirect().Document(c.Format)
+			if err != nil {
+				return nil, err
+			}
+			indirect, err := yaml.Marshal(document)
+			if err != nil {
+				return nil, err
+			}
+			documents[DocumentKey(IndirectKey, profile)] = string(indirect)
+		}
+
+		if c.Provenance {
+			provenance, err := yaml.Marshal(permission.Provenance)
+			if err != nil {
+				return nil, err
+			}
+			documents[DocumentKey(ProvenanceKey, profile)] = string(provenance)
+		}
+	}
+	return documents, nil
+}
+
+// WriteConfigmap writes the documents to the ConfigMap, creating it if it does not exist. Document keys not among the
+// documents, e.g. of removed profiles or disabled options, are removed, other keys are kept.
+func WriteConfigmap(clientset kubernetes.Interface, documents map[string]string, c Config) error {
+	cm, err := clientset.CoreV1().ConfigMaps(c.CMNamespace).Get(context.Background(), c.CMName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return createConfigMap(clientset, c, documents)
+	}
 	if err != nil {
 		return err
 	}
-	data := strings.Replace(string(permissions), "\n", "\\n", -1)
-	patch := []byte(fmt.Sprintf(`{"data":{"labels.yaml": "%s"}}`, data))
+	data := make(map[string]any, len(documents))
+	for key, document := range documents {
+		data[key] = document
+	}
+	// stale documents would keep granting access, a null value removes the key
+	for key := range cm.Data {
+		if _, ok := documents[key]; !ok && isDocumentKey(key) {
+			data[key] = nil
+		}
+	}
+	return patchConfigMap(clientset, c, data, documents)
+}
 
-	_, err = clientset.CoreV1().ConfigMaps(c.CMNamespace).Patch(context.Background(), c.CMName, types.MergePatchType, patch, metav1.PatchOptions{})
-	if err != nil {
-		if errors.IsNotFound(err) {
-			return createConfigMap(clientset, c, permissions)
+// isDocumentKey reports whether the key holds a document written by WriteConfigmap, of any profile.
+func isDocumentKey(key string) bool {
+	for _, base := range []string{LabelsKey, ProvenanceKey, IndirectKey} {
+		name := strings.TrimSuffix(base, ".yaml")
+		if key == base || strings.HasPrefix(key, name+"-") && strings.HasSuffix(key, ".yaml") {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
-func createConfigMap(clientset *kubernetes.Clientset, c Config, permissions []byte) error {
+// patchConfigMap merges data into the ConfigMap by a JSON merge patch, creating it with documents if it does not exist.
+func patchConfigMap(clientset kubernetes.Interface, c Config, data map[string]any, documents map[string]string) error {
+	patch, err := json.Marshal(map[string]any{"data": data})
+	if err != nil {
+		return err
+	}
+
+	_, err = clientset.CoreV1().ConfigMaps(c.CMNamespace).Patch(context.Background(), c.CMName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if errors.IsNotFound(err) {
+		return createConfigMap(clientset, c, documents)
+	}
+	return err
+}
+
+func createConfigMap(clientset kubernetes.Interface, c Config, documents map[string]string) error {
 	cm := v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      c.CMName,
 			Namespace: c.CMNamespace,
 		},
-		Immutable: nil,
-		Data: map[string]string{
-			"labels.yaml": string(permissions),
-		},
+		Immutable:  nil,
+		Data:       documents,
 		BinaryData: nil,
 	}
 	_, err := clientset.CoreV1().ConfigMaps(c.CMNamespace).Create(context.Background(), &cm, metav1.CreateOptions{})
@@ -49,6 +136,56 @@ func createConfigMap(clientset *kubernetes.Clientset, c Config, permissions []by
 	return nil
 }
 
+// ReadOverrides reads the overrides document from the file or ConfigMap of the source.
+func ReadOverrides(clientset kubernetes.Interface, source collector.OverridesSource) (collector.Overrides, error) {
+	if source.File != "" {
+		data, err := os.ReadFile(source.File)
+		if err != nil {
+			return collector.Overrides{}, fmt.Errorf("error reading overrides: %w", err)
+		}
+		return collector.ParseOverrides(data)
+	}
+	cm, err := clientset.CoreV1().ConfigMaps(source.ConfigMap.Namespace).Get(context.Background(), source.ConfigMap.Name, metav1.GetOptions{})
+	if err != nil {
+		return collector.Overrides{}, fmt.Errorf("error reading overrides: %w", err)
+	}
+	return OverridesFromConfigMap(cm, source.ConfigMap.Key)
+}
+
+// OverridesFromConfigMap parses the overrides document stored under key.
+func OverridesFromConfigMap(cm *v1.ConfigMap, key string) (collector.Overrides, error) {
+	data, ok := cm.Data[key]
+	if !ok {
+		return collector.Overrides{}, fmt.Errorf("overrides: ConfigMap %s/%s has no key %s", cm.Namespace, cm.Name, key)
+	}
+	return collector.ParseOverrides([]byte(data))
+}
+
+// ReadBreakGlassGrants reads the persisted break-glass grants, a missing ConfigMap or key holds no grants.
+func ReadBreakGlassGrants(clientset kubernetes.Interface, ref collector.ConfigMapReference) ([]collector.BreakGlassGrant, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(ref.Namespace).Get(context.Background(), ref.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading break-glass grants: %w", err)
+	}
+	return collector.ParseBreakGlassGrants([]byte(cm.Data[ref.Key]))
+}
+
+// WriteBreakGlassGrants persists the break-glass grants.
+func WriteBreakGlassGrants(clientset kubernetes.Interface, ref collector.ConfigMapReference, grants []collector.BreakGlassGrant) error {
+	if grants == nil {
+		grants = []collector.BreakGlassGrant{}
+	}
+	data, err := yaml.Marshal(grants)
+	if err != nil {
+		return err
+	}
+	documents := map[string]string{ref.Key: string(data)}
+	return patchConfigMap(clientset, Config{CMName: ref.Name, CMNamespace: ref.Namespace}, map[string]any{ref.Key: string(data)}, documents)
+}
+
 func MapsEqual(m1, m2 map[string]map[string]bool) bool {
 	if len(m1) != len(m2) {
 		return false
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/gepaplexx/multena-rbac-collector/collector"
+)
+
+func TestWriteConfigmap(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	config := Config{CMName: "labels", CMNamespace: "multena"}
+	read := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("multena").Get(context.Background(), "labels", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	documents := map[string]string{LabelsKey: "a", "labels-logs.yaml": "b", IndirectKey: "c", ProvenanceKey: "d", "provenance-logs.yaml": "e"}
+	assert.NoError(t, WriteConfigmap(clientset, documents, config), "creates the ConfigMap")
+	assert.Equal(t, documents, read())
+
+	cm, err := clientset.CoreV1().ConfigMaps("multena").Get(context.Background(), "labels", metav1.GetOptions{})
+	assert.NoError(t, err)
+	cm.Data["grants.yaml"] = "[]"
+	_, err = clientset.CoreV1().ConfigMaps("multena").Update(context.Background(), cm, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	// the logs profile was removed and provenance and impersonation disabled
+	assert.NoError(t, WriteConfigmap(clientset, map[string]string{LabelsKey: "f"}, config))
+	assert.Equal(t, map[string]string{LabelsKey: "f", "grants.yaml": "[]"}, read(), "stale documents are removed, other keys kept")
+}
+
+func TestWriteBreakGlassGrantsKeepsDocuments(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "labels", Namespace: "multena"},
+		Data:       map[string]string{LabelsKey: "a"},
+	})
+	ref := collector.ConfigMapReference{Name: "labels", Namespace: "multena", Key: "grants.yaml"}
+	assert.NoError(t, WriteBreakGlassGrants(clientset, ref, nil))
+	cm, err := clientset.CoreV1().ConfigMaps("multena").Get(context.Background(), "labels", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{LabelsKey: "a", "grants.yaml": "[]\n"}, cm.Data)
+}